@@ -2,14 +2,31 @@
 package main
 
 import (
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
 	rtsp "github.com/SimpleRtsp/rtsp"
 )
 
 func main() {
+	configFile := flag.String("config", "", "config file (default config.json in the working directory)")
+	flag.Parse()
+
 	rtsp := rtsp.NewRtspServer()
+	rtsp.ConfigFile = *configFile
 	if err := rtsp.GetConfig(); err != nil {
 		return
 	}
+	rtsp.WatchConfig()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			rtsp.Reload()
+		}
+	}()
 	if ok := rtsp.Start(); ok == false {
 		return
 	}
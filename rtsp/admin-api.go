@@ -0,0 +1,246 @@
+// admin-api
+package rtsp
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+json over http for dashboards and scripts:
+GET /api/mounts, POST /api/mounts (a mount as in config.json), DELETE /api/mounts/<path>
+GET /api/sessions, DELETE /api/sessions/<id>
+GET /metrics for prometheus
+*/
+
+type MountStatus struct {
+	Path     string   `json:"path"`
+	Kind     string   `json:"kind"`  /*file, publish or relay*/
+	State    string   `json:"state"` /*ready, error, waiting, publishing, idle or relaying*/
+	Source   string   `json:"source,omitempty"`
+	Codecs   []string `json:"codecs"`
+	Duration float64  `json:"duration,omitempty"`
+	Sessions int      `json:"sessions"`
+}
+
+type SessionStatus struct {
+	ID          string    `json:"id"`
+	Mount       string    `json:"mount,omitempty"`
+	Remote      string    `json:"remote,omitempty"`
+	State       string    `json:"state"` /*ready, playing or recording*/
+	Transports  []string  `json:"transports"`
+	BytesSent   uint64    `json:"bytes_sent"`
+	PacketsSent uint64    `json:"packets_sent"`
+	Started     time.Time `json:"started"`
+}
+
+/*"96 H264/90000" -> H264*/
+func encodingName(rtpMap string) string {
+	fields := strings.Fields(rtpMap)
+	if len(fields) < 2 {
+		return ""
+	}
+	return strings.ToUpper(strings.Split(fields[1], "/")[0])
+}
+
+func (s *MediaStream) Status() MountStatus {
+	st := MountStatus{
+		Path:   s.Path,
+		Kind:   "file",
+		State:  "ready",
+		Source: s.FileName,
+		Codecs: []string{},
+	}
+	if s.IsLive() {
+		st.Kind, st.State, st.Source = "publish", "waiting", ""
+		if s.Source != "" {
+			st.Kind, st.State, st.Source = "relay", "idle", redactUrl(s.Source)
+		}
+		if live := s.Live(); live != nil {
+			st.State = map[string]string{"publish": "publishing", "relay": "relaying"}[st.Kind]
+			for _, t := range live.Tracks {
+				st.Codecs = append(st.Codecs, encodingName(t.RtpMap))
+			}
+		}
+		return st
+	}
+	for i := range s.FileTracks() {
+		info, err := s.TrackInfo(i)
+		if err != nil {
+			st.State = "error"
+			continue
+		}
+		st.Codecs = append(st.Codecs, info.Codec)
+		if info.Duration > st.Duration {
+			st.Duration = info.Duration
+		}
+	}
+	return st
+}
+
+func (s *RtspSession) Status() SessionStatus {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	st := SessionStatus{
+		ID:         s.ID,
+		State:      "ready",
+		Transports: []string{},
+		Started:    s.created,
+	}
+	if s.stream != nil {
+		st.Mount = s.stream.Path
+	}
+	if s.conn != nil {
+		st.Remote = s.conn.Conn.RemoteAddr().String()
+	}
+	if s.playing {
+		st.State = "playing"
+	}
+	if s.recording {
+		st.State = "recording"
+	}
+	for _, ts := range []map[int]*RtpTransport{s.transports, s.recordTransports} {
+		for _, t := range ts {
+			st.Transports = append(st.Transports, t.String())
+			st.BytesSent += uint64(atomic.LoadUint32(&t.OctetsSent))
+			st.PacketsSent += uint64(atomic.LoadUint32(&t.PacketsSent))
+		}
+	}
+	return st
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (r *RtspServer) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/mounts", r.handleMounts)
+	mux.HandleFunc("/api/mounts/", r.handleMount)
+	mux.HandleFunc("/api/sessions", r.handleSessions)
+	mux.HandleFunc("/api/sessions/", r.handleSession)
+	mux.HandleFunc("/metrics", r.handleMetrics)
+	token := r.AdminToken /*a new token takes a restart, like the address*/
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if token != "" && subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			writeJSONError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		mux.ServeHTTP(w, req)
+	})
+}
+
+func (r *RtspServer) handleMounts(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		mounts := []MountStatus{}
+		for _, s := range r.Streams() {
+			st := s.Status()
+			st.Sessions = len(r.streamSessions(s))
+			mounts = append(mounts, st)
+		}
+		sort.Slice(mounts, func(i, j int) bool { return mounts[i].Path < mounts[j].Path })
+		writeJSON(w, http.StatusOK, mounts)
+	case http.MethodPost:
+		var cfg MountConfig
+		if err := json.NewDecoder(req.Body).Decode(&cfg); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		if strings.Trim(cfg.Path, "/ ") == "" {
+			writeJSONError(w, http.StatusBadRequest, errors.New("a mount needs a path"))
+			return
+		}
+		if problems := cfg.problems(); len(problems) > 0 {
+			writeJSONError(w, http.StatusBadRequest, errors.New(strings.Join(problems, "; ")))
+			return
+		}
+		s := NewMediaStream(&cfg)
+		if err := r.AddStream(s); err != nil {
+			writeJSONError(w, http.StatusConflict, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, s.Status())
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+/*DELETE /api/mounts/a/b removes /a/b and ends its sessions*/
+func (r *RtspServer) handleMount(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodDelete {
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	if r.dropStream(strings.TrimPrefix(req.URL.Path, "/api/mounts")) == nil {
+		writeJSONError(w, http.StatusNotFound, errors.New("no such mount"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *RtspServer) handleSessions(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	sessions := []SessionStatus{}
+	for _, s := range r.sessions.Sessions() {
+		sessions = append(sessions, s.Status())
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Started.Before(sessions[j].Started) })
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+/*DELETE /api/sessions/<id> kicks the client: BYE and TEARDOWN as on shutdown*/
+func (r *RtspServer) handleSession(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodDelete {
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	s := r.sessions.Get(strings.TrimPrefix(req.URL.Path, "/api/sessions/"))
+	if s == nil {
+		writeJSONError(w, http.StatusNotFound, errors.New("no such session"))
+		return
+	}
+	s.shutdown()
+	log.Printf("session %s kicked\n", s.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *RtspServer) streamSessions(stream *MediaStream) []*RtspSession {
+	var ss []*RtspSession
+	for _, s := range r.sessions.Sessions() {
+		s.lock.Lock()
+		if s.stream == stream {
+			ss = append(ss, s)
+		}
+		s.lock.Unlock()
+	}
+	return ss
+}
+
+func listenAdmin(addr string) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("admin api on", l.Addr())
+	return l, nil
+}
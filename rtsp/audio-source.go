@@ -0,0 +1,216 @@
+// audio-source
+package rtsp
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	CodecAAC  = "MPEG4-GENERIC"
+	CodecPCMU = "PCMU"
+	CodecPCMA = "PCMA"
+)
+
+var aacSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+/*audio files are recognised by extension, anything else is taken for h264/h265*/
+func audioCodec(fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".aac", ".adts":
+		return CodecAAC
+	case ".pcmu", ".ulaw", ".g711u":
+		return CodecPCMU
+	case ".pcma", ".alaw", ".g711a":
+		return CodecPCMA
+	}
+	return ""
+}
+
+/*AAC in ADTS framing, sent as RFC 3640 AAC-hbr with one access unit per packet*/
+type AACFileSource struct {
+	SampleRate int
+	Channels   int
+	Config     string /*AudioSpecificConfig in hex for the fmtp line*/
+	offset     int
+	data       []byte
+}
+
+func NewAACFileSource(fileName string) (*AACFileSource, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	a := &AACFileSource{
+		SampleRate: 0,
+		Channels:   0,
+		offset:     0,
+		data:       data,
+	}
+	if a.nextHeader() < 0 {
+		return nil, fmt.Errorf("%s has no adts header", fileName)
+	}
+	/*adts header: profile(2) sampling_frequency_index(4) private(1) channel_configuration(3)*/
+	profile := int(data[a.offset+2]>>6) + 1
+	freqIndex := int(data[a.offset+2]>>2) & 0x0f
+	if freqIndex >= len(aacSampleRates) {
+		return nil, errors.New("invalid aac sampling frequency")
+	}
+	a.SampleRate = aacSampleRates[freqIndex]
+	a.Channels = int(data[a.offset+2]&0x01)<<2 | int(data[a.offset+3]>>6)
+	a.Config = fmt.Sprintf("%04X", profile<<11|freqIndex<<7|a.Channels<<3)
+	return a, nil
+}
+
+/*7 bytes, 9 when a crc follows (protection_absent == 0)*/
+func (a *AACFileSource) headerLen() int {
+	if a.data[a.offset+1]&0x01 == 0 {
+		return 9
+	}
+	return 7
+}
+
+/*move to the next 0xFFF syncword, returns the frame length or -1 at the end; frames too short for their header are skipped*/
+func (a *AACFileSource) nextHeader() int {
+	for ; a.offset+7 <= len(a.data); a.offset++ {
+		if a.data[a.offset] != 0xff || a.data[a.offset+1]&0xf0 != 0xf0 {
+			continue
+		}
+		frameLen := int(a.data[a.offset+3]&0x03)<<11 | int(a.data[a.offset+4])<<3 | int(a.data[a.offset+5]>>5)
+		if frameLen > a.headerLen() && a.offset+frameLen <= len(a.data) {
+			return frameLen
+		}
+	}
+	return -1
+}
+
+func (a *AACFileSource) ClockRate() uint32 {
+	return uint32(a.SampleRate)
+}
+
+func (a *AACFileSource) PayloadType() byte {
+	return 97
+}
+
+func (a *AACFileSource) NextFrame(rtp *RtpPacket, timestamp uint32) ([]string, uint32) {
+	frameLen := a.nextHeader()
+	if frameLen < 0 {
+		return nil, 0
+	}
+	headerLen := a.headerLen()
+	frame := a.data[a.offset+headerLen : a.offset+frameLen]
+	a.offset += frameLen
+
+	/*AU-headers-length(16) AU-size(13) AU-index(3)*/
+	payload := make([]byte, 4+len(frame))
+	payload[0] = 0x00
+	payload[1] = 0x10
+	payload[2] = byte(len(frame) >> 5)
+	payload[3] = byte(len(frame)&0x1f) << 3
+	copy(payload[4:], frame)
+	return []string{rtp.BuildRTP(true, payload, timestamp)}, 1024
+}
+
+func (a *AACFileSource) Seek(timestamp uint32) uint32 {
+	a.offset = 0
+	ts := uint32(0)
+	for frameLen := a.nextHeader(); frameLen > 0 && ts+1024 <= timestamp; frameLen = a.nextHeader() {
+		a.offset += frameLen
+		ts += 1024
+	}
+	return ts
+}
+
+/*raw 8kHz mono G.711, 20ms per packet*/
+type G711FileSource struct {
+	Codec  string
+	offset int
+	data   []byte
+}
+
+func NewG711FileSource(fileName string) (*G711FileSource, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return &G711FileSource{
+		Codec:  audioCodec(fileName),
+		offset: 0,
+		data:   data,
+	}, nil
+}
+
+func (g *G711FileSource) ClockRate() uint32 {
+	return 8000
+}
+
+func (g *G711FileSource) PayloadType() byte {
+	if g.Codec == CodecPCMA {
+		return 8
+	}
+	return 0
+}
+
+func (g *G711FileSource) NextFrame(rtp *RtpPacket, timestamp uint32) ([]string, uint32) {
+	if g.offset >= len(g.data) {
+		return nil, 0
+	}
+	end := g.offset + 160
+	if end > len(g.data) {
+		end = len(g.data)
+	}
+	payload := g.data[g.offset:end]
+	g.offset = end
+	return []string{rtp.BuildRTP(false, payload, timestamp)}, uint32(len(payload))
+}
+
+/*one byte per sample, packet aligned*/
+func (g *G711FileSource) Seek(timestamp uint32) uint32 {
+	offset := int(timestamp / 160 * 160)
+	if offset > len(g.data) {
+		offset = len(g.data)
+	}
+	g.offset = offset
+	return uint32(offset)
+}
+
+func probeAudio(fileName string) (*StreamInfo, error) {
+	switch codec := audioCodec(fileName); codec {
+	case CodecAAC:
+		a, err := NewAACFileSource(fileName)
+		if err != nil {
+			return nil, err
+		}
+		frames := 0
+		for frameLen := a.nextHeader(); frameLen > 0; frameLen = a.nextHeader() {
+			a.offset += frameLen
+			frames++
+		}
+		return &StreamInfo{
+			MediaType:   "audio",
+			Codec:       codec,
+			PayloadType: int(a.PayloadType()),
+			SampleRate:  a.SampleRate,
+			Channels:    a.Channels,
+			Config:      a.Config,
+			Duration:    float64(frames*1024) / float64(a.SampleRate),
+		}, nil
+	case CodecPCMU, CodecPCMA:
+		g, err := NewG711FileSource(fileName)
+		if err != nil {
+			return nil, err
+		}
+		return &StreamInfo{
+			MediaType:   "audio",
+			Codec:       codec,
+			PayloadType: int(g.PayloadType()),
+			SampleRate:  8000,
+			Channels:    1,
+			Duration:    float64(len(g.data)) / 8000,
+		}, nil
+	}
+	return nil, fmt.Errorf("%s is not an audio file", fileName)
+}
@@ -8,84 +8,102 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
-	"unsafe"
 )
 
-const allowedCommandNames = "OPTIONS, DESCRIBE, SETUP, TEARDOWN, PLAY"
+const allowedCommandNames = "OPTIONS, DESCRIBE, ANNOUNCE, SETUP, TEARDOWN, PLAY, PAUSE, RECORD, GET_PARAMETER"
+
+const maxBodySize = 64 << 10 /*request bodies are sdp or parameters, a few KB at most*/
 
 type ClientConnection struct {
-	Conn        net.Conn
-	rtsp        *RtspServer
-	ConnRW      *bufio.ReadWriter
-	RtpChannel  int
-	RtcpChannel int
-	ID          string
+	Conn         net.Conn
+	rtsp         *RtspServer
+	ConnRW       *bufio.ReadWriter
+	stream       *MediaStream
+	session      *RtspSession
+	sessions     map[string]*RtspSession
+	writeLock    sync.Mutex
+	reqLock      sync.Mutex /*sessions and requests: a tunnel handles them on its POST goroutine*/
+	tunnelCookie string     /*set on the GET half of an http tunnel*/
+	tunnelPosted bool       /*a POST is attached to this GET, under rtsp.tunnelLock*/
+	cseq         int32      /*of requests the server sends*/
+	user         string     /*authenticated user name*/
 }
 
 func NewConnection(con net.Conn, r *RtspServer) *ClientConnection {
 	return &ClientConnection{
-		Conn:        con,
-		rtsp:        r,
-		ConnRW:      bufio.NewReadWriter(bufio.NewReaderSize(con, 204800), bufio.NewWriterSize(con, 204800)),
-		RtpChannel:  -1,
-		RtcpChannel: -1,
-		ID:          "",
+		Conn:         con,
+		rtsp:         r,
+		ConnRW:       bufio.NewReadWriter(bufio.NewReaderSize(con, 204800), bufio.NewWriterSize(con, 204800)),
+		stream:       nil,
+		session:      nil,
+		sessions:     make(map[string]*RtspSession),
+		tunnelCookie: "",
+		tunnelPosted: false,
+		cseq:         0,
+		user:         "",
 	}
 }
 
 func (c *ClientConnection) Start() {
 	defer c.Conn.Close()
+	defer c.closeSessions()
+	defer c.rtsp.removeTunnel(c)
 	log.Printf("new connect:%v\n", c.Conn.RemoteAddr())
+	if err := c.rtsp.checkHooks(c.hookEvent(HookConnect, "", nil, "")); err != nil {
+		return
+	}
+	defer func() {
+		c.rtsp.notifyHooks(c.hookEvent(HookDisconnect, "", nil, ""))
+	}()
+	c.serve(c.ConnRW.Reader)
+}
+
+/*reads requests and interleaved data until rd fails, rd is the connection itself or the decoded POST of an http tunnel*/
+func (c *ClientConnection) serve(rd *bufio.Reader) {
 	buf1 := make([]byte, 1)
 	buf2 := make([]byte, 2)
 	for {
-		if _, err := io.ReadFull(c.ConnRW, buf1); err != nil {
+		if _, err := io.ReadFull(rd, buf1); err != nil {
 			log.Println(err)
 			return
 		}
 		if buf1[0] == 0x24 {
-			if _, err := io.ReadFull(c.ConnRW, buf1); err != nil {
+			if _, err := io.ReadFull(rd, buf1); err != nil {
 				log.Println(err)
 				return
 			} /*channel*/
-			if _, err := io.ReadFull(c.ConnRW, buf2); err != nil {
+			if _, err := io.ReadFull(rd, buf2); err != nil {
 				log.Println(err)
 				return
 			} /*size*/
 			dataSize := binary.BigEndian.Uint16(buf2)
 			data := make([]byte, dataSize)
-			if _, err := io.ReadFull(c.ConnRW, data); err != nil {
+			if _, err := io.ReadFull(rd, data); err != nil {
 				log.Println(err)
 				return
 			}
-			if int(buf1[0]) == c.RtcpChannel {
-				//rc := data[0] & 0x1f
-				switch data[1] {
-				case 200: /*sender report*/
-					log.Printf("rtcp packet type sender report.dataSize:%d\n", dataSize)
-				case 201: /*receiver report*/
-					log.Printf("rtcp packet type receiver report.dataSize:%d\n", dataSize)
-				case 202: /*source description item*/
-					log.Printf("rtcp packet type source description item.dataSize:%d\n", dataSize)
-				case 203: /*byte*/
-					log.Printf("rtcp packet type byte.dataSize:%d\n", dataSize)
-				case 204: /*app*/
-					log.Printf("rtcp packet type app.dataSize:%d\n", dataSize)
+			c.reqLock.Lock()
+			for _, s := range c.sessions {
+				if s.onInterleaved(int(buf1[0]), data) {
+					s.touch()
+					break
 				}
-			} else if int(buf1[0]) == c.RtpChannel {
-
 			}
+			c.reqLock.Unlock()
 
 		} else {
 			reqBuf := bytes.NewBuffer(nil)
 			reqBuf.Write(buf1)
 			for {
-				if line, isPrefix, err := c.ConnRW.ReadLine(); err != nil {
+				if line, isPrefix, err := rd.ReadLine(); err != nil {
 					log.Println(err)
 					return
 				} else {
@@ -94,43 +112,41 @@ func (c *ClientConnection) Start() {
 						reqBuf.WriteString("\r\n")
 					}
 					if len(line) == 0 {
-						log.Println(reqBuf.String())
+						log.Println(redactAuthorization(reqBuf.String()))
 						req := ParseReqBuf(reqBuf.String())
 						if req == nil {
 							break
 						}
-
-						var resp string
-						switch req.Method {
-						case "OPTIONS":
-							resp = c.handleCmdOPTIONS(req.Headers["CSeq"])
-						case "DESCRIBE":
-							resp = c.handleCmdDESCRIBE(req.Headers["CSeq"])
-						case "SETUP":
-							ts := req.Headers["Transport"] /*Transport: RTP/AVP/TCP;unicast;interleaved=0-1*/
-							mtcp := regexp.MustCompile("interleaved=(\\d+)-(\\d+)?")
-							mudp := regexp.MustCompile("client_port=(\\d+)-(\\d+)?")
-							if mts := mtcp.FindStringSubmatch(ts); mts != nil {
-								c.RtpChannel, err = strconv.Atoi(mts[1])
-								c.RtcpChannel, err = strconv.Atoi(mts[2])
-								c.ID = fmt.Sprintf("%X", unsafe.Pointer(c))
-								resp = c.handleCmdSETUP(req.Headers["CSeq"], 0)
-							} else if mus := mudp.FindStringSubmatch(ts); mus != nil {
-								log.Println("udp")
+						if strings.HasPrefix(req.Method, "RTSP/") { /*the client answering a request of ours*/
+							break
+						}
+						if strings.HasPrefix(req.Version, "HTTP/") && rd == c.ConnRW.Reader {
+							if !c.openTunnel(req, rd) {
+								return
 							}
-						case "PLAY":
-							resp = c.handleCmdPLAY(req.Headers["CSeq"])
-						case "TEARDOWN":
-							resp = c.handleCmdTEARDOWN(req.Headers["CSeq"])
-						default:
-							resp = c.handleCmdNOTFOUND(req.Headers["CSeq"])
+							break
 						}
-						log.Println(resp)
-						c.ConnRW.WriteString(resp)
-						c.ConnRW.Flush()
-						if strings.Compare(req.Method, "PLAY") == 0 {
-							log.Println("start play")
-							go c.StartPlay()
+						if contentLength, ok := req.Headers["Content-Length"]; ok {
+							n, err := strconv.Atoi(strings.TrimSpace(contentLength))
+							if err != nil || n < 0 || n > maxBodySize {
+								/*the body cannot be skipped safely, answer and drop the connection*/
+								status := "400 Bad Request"
+								if err == nil && n > maxBodySize {
+									status = "413 Request Entity Too Large"
+								}
+								c.writeResponse(c.handleCmdERROR(req.Headers["CSeq"], status))
+								return
+							}
+							req.Body = make([]byte, n)
+							if _, err := io.ReadFull(rd, req.Body); err != nil {
+								log.Println(err)
+								return
+							}
+						}
+
+						if err := c.handle(req); err != nil {
+							log.Println(err)
+							return
 						}
 						break
 					}
@@ -140,90 +156,457 @@ func (c *ClientConnection) Start() {
 	}
 }
 
-func (c *ClientConnection) handleCmdOPTIONS(cseq string) string {
-	return fmt.Sprintf("RTSP/1.0 200 OK\r\nCSeq: %s\r\nDate: %s\r\nPublic: %s\r\n\r\n", cseq,
-		time.Now().Format("Mon, Jan 2 2006 15:04:05 GMT"), allowedCommandNames)
+func (c *ClientConnection) handle(req *RequestInfo) error {
+	c.reqLock.Lock()
+	defer c.reqLock.Unlock()
+	resp, after := c.handleRequest(req)
+	log.Println(resp)
+	countRequest(req.Method, resp)
+	if err := c.writeResponse(resp); err != nil {
+		return err
+	}
+	if after != nil {
+		after()
+	}
+	return nil
 }
 
-func (c *ClientConnection) handleCmdDESCRIBE(cseq string) string {
-	sdp := fmt.Sprintf("v=0\r\n"+
-		"o=- %d %d IN IP4 %s\r\n"+
-		"c=IN IP4 %s\r\n"+
-		"t=0 0\r\n"+
-		"a=range:npt=0-\r\n"+
-		"m=video 0 RTP/AVP 96\r\n"+
-		"a=rtpmap:96 H264/90000\r\n", time.Now().Unix(), time.Now().Unix(), c.rtsp.Host, c.rtsp.Host)
+/*returns the response and what to run once it has been sent*/
+func (c *ClientConnection) handleRequest(req *RequestInfo) (string, func()) {
+	cseq := req.Headers["CSeq"]
+	if req.Method != "OPTIONS" && c.rtsp.auth.Enabled() {
+		user, err := c.rtsp.auth.Verify(req.Method, req.URL, req.Headers["Authorization"])
+		if err != nil {
+			log.Printf("%v unauthorized: %v\n", c.Conn.RemoteAddr(), err)
+			if _, ok := req.Headers["Authorization"]; ok && err != errStaleNonce { /*a request without credentials is only the challenge round*/
+				Metrics.Add("rtsp_auth_failures_total", 1)
+			}
+			return c.handleCmdUNAUTHORIZED(cseq, err == errStaleNonce), nil
+		}
+		c.user = user
+	}
+	s := c.session
+	if id, ok := req.Headers["Session"]; ok {
+		if s = c.rtsp.sessions.Get(id); s == nil {
+			return c.handleCmdERROR(cseq, "454 Session Not Found"), nil
+		}
+		s.lock.Lock() /*the session follows the client's latest connection, Status and shutdown read it*/
+		s.conn = c
+		s.lock.Unlock()
+	}
+	if s != nil {
+		s.touch()
+	}
+
+	switch req.Method {
+	case "OPTIONS":
+		return c.handleCmdOPTIONS(cseq), nil
+	case "GET_PARAMETER": /*keepalive*/
+		return c.handleCmdGETPARAMETER(cseq, s), nil
+	case "DESCRIBE":
+		if c.stream = c.rtsp.FindStream(req.URL); c.stream == nil || (c.stream.IsLive() && c.stream.AcquireLive() == nil) {
+			return c.handleCmdNOTFOUND(cseq), nil
+		}
+		if err := c.rtsp.checkHooks(c.hookEvent(HookDescribe, c.stream.Path, s, req.URL)); err != nil {
+			return c.handleCmdERROR(cseq, "403 Forbidden"), nil
+		}
+		return c.handleCmdDESCRIBE(cseq, req.URL), nil
+	case "ANNOUNCE":
+		return c.announce(req), nil
+	case "SETUP":
+		if s != nil && s.live != nil {
+			return c.setupRecord(req, s), nil
+		}
+		return c.setupPlay(req, s), nil
+	case "PLAY":
+		if s == nil || !s.hasTransports() {
+			return c.handleCmdERROR(cseq, "454 Session Not Found"), nil
+		}
+		if err := c.rtsp.checkHooks(c.hookEvent(HookPlay, s.stream.Path, s, req.URL)); err != nil {
+			return c.handleCmdERROR(cseq, "403 Forbidden"), nil
+		}
+		if err := s.preparePlay(); err != nil {
+			log.Println(err)
+			return c.handleCmdNOTFOUND(cseq), nil
+		}
+		scale, speed := s.rate()
+		if v, ok := req.Headers["Scale"]; ok {
+			scale = acceptScale(v, s)
+		}
+		if v, ok := req.Headers["Speed"]; ok {
+			speed = acceptSpeed(v, s)
+		}
+		if !s.stream.IsLive() {
+			s.setRate(scale, speed)
+		}
+		if rng, ok := req.Headers["Range"]; ok && !s.stream.IsLive() {
+			start, end, ok := parseNptRange(rng)
+			if !ok || (end > 0 && scale > 0 && end <= start) || (end > 0 && scale < 0 && end >= start) || (s.stream.Duration() > 0 && start > s.stream.Duration()) {
+				return c.handleCmdERROR(cseq, "457 Invalid Range"), nil
+			}
+			if start >= 0 {
+				s.seek(start, end)
+			}
+		}
+		rate := ""
+		if _, ok := req.Headers["Scale"]; ok {
+			rate += fmt.Sprintf("Scale: %.3f\r\n", scale)
+		}
+		if _, ok := req.Headers["Speed"]; ok {
+			rate += fmt.Sprintf("Speed: %.3f\r\n", speed)
+		}
+		return c.handleCmdPLAY(cseq, req.URL, s, rate), func() {
+			log.Println("start play")
+			s.startPlay()
+		}
+	case "PAUSE":
+		if s == nil || !s.hasTransports() {
+			return c.handleCmdERROR(cseq, "454 Session Not Found"), nil
+		}
+		s.pausePlay()
+		return c.handleCmdPAUSE(cseq, s), nil
+	case "RECORD":
+		if s == nil || !s.startRecord() {
+			return c.handleCmdERROR(cseq, "455 Method Not Valid in This State"), nil
+		}
+		return c.handleCmdRECORD(cseq, s), nil
+	case "TEARDOWN":
+		if s == nil {
+			return c.handleCmdERROR(cseq, "454 Session Not Found"), nil
+		}
+		c.rtsp.notifyHooks(c.hookEvent(HookTeardown, s.Status().Mount, s, req.URL))
+		c.removeSession(s)
+		return c.handleCmdTEARDOWN(cseq, s), nil
+	}
+	return c.handleCmdNOTFOUND(cseq), nil
+}
 
-	return fmt.Sprintf("RTSP/1.0 200 OK\r\nCSeq: %s\r\nDate: %s\r\nContent-Type: application/sdp\r\nContent-Length: %d\r\n\r\n%s", cseq,
-		time.Now().Format("Mon, Jan 2 2006 15:04:05 GMT"), len(sdp), sdp)
+func parseTransport(ts string) (interleaved []int, clientPorts []int) {
+	mtcp := regexp.MustCompile("interleaved=(\\d+)(?:-(\\d+))?")
+	mudp := regexp.MustCompile("client_port=(\\d+)(?:-(\\d+))?")
+	pair := func(m []string) []int {
+		a, _ := strconv.Atoi(m[1])
+		b := a + 1
+		if m[2] != "" {
+			b, _ = strconv.Atoi(m[2])
+		}
+		return []int{a, b}
+	}
+	if mts := mtcp.FindStringSubmatch(ts); mts != nil {
+		interleaved = pair(mts)
+	}
+	if mus := mudp.FindStringSubmatch(ts); mus != nil {
+		clientPorts = pair(mus)
+	}
+	return
 }
 
-func (c *ClientConnection) handleCmdSETUP(cseq string, streamMode int) string {
-	if streamMode == 0 { /*tcp*/
-		return fmt.Sprintf("RTSP/1.0 200 OK\r\nCSeq: %s\r\nDate: %s\r\nTransport: RTP/AVP/TCP;unicast;interleaved=%d-%d;\r\nSession: %s\r\n\r\n", cseq,
-			time.Now().Format("Mon, Jan 2 2006 15:04:05 GMT"), c.RtpChannel, c.RtcpChannel, c.ID)
-	} else if streamMode == 1 { /*udp*/
-		return fmt.Sprintf("RTSP/1.0 200 OK\r\nCSeq: %s\r\nDate: %s\r\nTransport: RTP/AVP;unicast;destination=%s;source=%s;client_port=%d-%d;server_port=%d-%d\r\nSession: %s\r\n\r\n", cseq,
-			time.Now().Format("Mon, Jan 2 2006 15:04:05 GMT"), c.RtpChannel, c.RtcpChannel, c.ID)
+func transportProtocol(ts string, interleaved []int) ProtocolName {
+	if interleaved != nil {
+		return TCP
+	} else if strings.Contains(ts, "multicast") {
+		return Multicast
 	}
-	return ""
+	return UDP
 }
 
-func (c *ClientConnection) handleCmdPLAY(cseq string) string {
-	rng := "Range: npt=0.000-\r\n"
-	rtpInfo := "RTP-Info: seq=0;rtptime=0\r\n"
-	return fmt.Sprintf("RTSP/1.0 200 OK\r\nCSeq: %s\r\nDate: %s\r\n%sSession: %s\r\n%s\r\n", cseq,
-		time.Now().Format("Mon, Jan 2 2006 15:04:05 GMT"), rng, c.ID, rtpInfo)
+func (c *ClientConnection) newSession() *RtspSession {
+	s := c.rtsp.sessions.NewSession(c)
+	c.sessions[s.ID] = s
+	c.session = s
+	return s
 }
 
-func (c *ClientConnection) handleCmdTEARDOWN(cseq string) string {
-	return fmt.Sprintf("RTSP/1.0 200 OK\r\nCSeq: %s\r\nDate: %s\r\nSession: %s\r\nConnection: Close\r\n\r\n", cseq,
-		time.Now().Format("Mon, Jan 2 2006 15:04:05 GMT"), c.ID)
+func (c *ClientConnection) removeSession(s *RtspSession) {
+	delete(c.sessions, s.ID)
+	if c.session == s {
+		c.session = nil
+	}
+	c.rtsp.sessions.Remove(s)
 }
 
-func (c *ClientConnection) handleCmdNOTFOUND(cseq string) string {
-	return fmt.Sprintf("RTSP/1.0 404 Stream Not Found\r\nCSeq: %s\r\nDate: %s\r\n", cseq, time.Now().Format("Mon, Jan 2 2006 15:04:05 GMT"))
+/*interleaved sessions die with the connection, udp/multicast ones wait for their timeout*/
+func (c *ClientConnection) closeSessions() {
+	c.reqLock.Lock()
+	defer c.reqLock.Unlock()
+	for _, s := range c.sessions {
+		s.lock.Lock()
+		interleaved := s.isInterleaved()
+		s.lock.Unlock()
+		if interleaved {
+			c.removeSession(s)
+		}
+	}
 }
 
-func (c *ClientConnection) StartPlay() {
-	mf := NewMediaFileSource()
-	if err := mf.ReadFileData("2m.h264"); err != nil {
+func (c *ClientConnection) setupPlay(req *RequestInfo, s *RtspSession) string {
+	cseq := req.Headers["CSeq"]
+	if s == nil && c.rtsp.sessionsFull() {
+		return c.handleCmdERROR(cseq, "453 Not Enough Bandwidth")
+	}
+	stream := c.rtsp.FindStream(req.URL)
+	if stream == nil || (stream.IsLive() && stream.AcquireLive() == nil) {
+		return c.handleCmdNOTFOUND(cseq)
+	}
+	track := urlTrackID(req.URL)
+	if track >= stream.TrackCount() {
+		return c.handleCmdNOTFOUND(cseq)
+	}
+	ts := req.Headers["Transport"] /*Transport: RTP/AVP/TCP;unicast;interleaved=0-1*/
+	interleaved, clientPorts := parseTransport(ts)
+	if !c.rtsp.transportAllowed(transportProtocol(ts, interleaved)) {
+		return c.handleCmdERROR(cseq, "461 Unsupported Transport")
+	}
+	var t *RtpTransport
+	if interleaved != nil {
+		t = NewTCPTransport(c, interleaved[0], interleaved[1])
+	} else if c.secure() { /*rtp over udp would leave the tls connection in the clear*/
+		return c.handleCmdERROR(cseq, "461 Unsupported Transport")
+	} else if strings.Contains(ts, "multicast") {
+		g, err := stream.getMulticastGroup(c.rtsp, track)
+		if err != nil {
+			log.Println(err)
+			return c.handleCmdERROR(cseq, "461 Unsupported Transport")
+		}
+		t = g.NewTransport()
+	} else if clientPorts != nil {
+		var err error
+		if t, err = NewUDPTransport(c, remoteIP(c.Conn), clientPorts[0], clientPorts[1]); err != nil {
+			log.Println(err)
+			return c.handleCmdERROR(cseq, "453 Not Enough Bandwidth")
+		}
+	} else {
+		return c.handleCmdERROR(cseq, "461 Unsupported Transport")
+	}
+	t.Track = track
+	if s == nil {
+		s = c.newSession()
+	}
+	s.setTransport(stream, t)
+	return c.handleCmdSETUP(cseq, t.String(), s)
+}
+
+func (c *ClientConnection) announce(req *RequestInfo) string {
+	cseq := req.Headers["CSeq"]
+	if !strings.Contains(req.Headers["Content-Type"], "application/sdp") || len(req.Body) == 0 {
+		return c.handleCmdERROR(cseq, "415 Unsupported Media Type")
+	}
+	tracks, err := parseAnnounceSDP(req.Body)
+	if err != nil {
 		log.Println(err)
-		return
+		return c.handleCmdERROR(cseq, "400 Bad Request")
 	}
-	rtp := NewRtpPacket(0, 0, 0x60)
-	var pts uint32 = 0
-	rtpPrefix := make([]byte, 4)
-	for {
-		nalu := mf.GetNextNalu()
-		if nalu == nil {
-			log.Println("end file")
-			return
+	if c.rtsp.sessionsFull() {
+		return c.handleCmdERROR(cseq, "453 Not Enough Bandwidth")
+	}
+	if err := c.rtsp.checkHooks(c.hookEvent(HookPublishStart, urlMountPath(req.URL), nil, req.URL)); err != nil {
+		return c.handleCmdERROR(cseq, "403 Forbidden")
+	}
+	stream := c.rtsp.GetStream(urlMountPath(req.URL))
+	if stream == nil {
+		if !c.rtsp.dynamicMounts() {
+			return c.handleCmdNOTFOUND(cseq)
 		}
-		var pkts []string
-		if (nalu[0]&0x1f) == 5 || (nalu[0]&0x1f) == 1 {
-			pkts = rtp.BuildRTPWithAVCNALU(true, nalu, pts)
-		} else {
-			pkts = rtp.BuildRTPWithAVCNALU(false, nalu, pts)
+		/*publishing to an unknown path creates a mount that lives as long as the publisher*/
+		stream = NewMediaStream(&MountConfig{Path: urlMountPath(req.URL), Publish: true})
+		stream.dynamic = true
+		if err := c.rtsp.AddStream(stream); err != nil {
+			/*somebody else made it meanwhile, and may have removed it again*/
+			if stream = c.rtsp.GetStream(stream.Path); stream == nil {
+				return c.handleCmdERROR(cseq, "455 Method Not Valid in This State")
+			}
+		}
+	}
+	s := c.newSession()
+	if err := s.startPublish(stream, tracks); err != nil {
+		log.Println(err)
+		c.removeSession(s)
+		if stream.Publish {
+			return c.handleCmdERROR(cseq, "455 Method Not Valid in This State")
 		}
+		return c.handleCmdERROR(cseq, "403 Forbidden")
+	}
+	return fmt.Sprintf("RTSP/1.0 200 OK\r\nCSeq: %s\r\nDate: %s\r\nSession: %s\r\n\r\n", cseq,
+		time.Now().Format("Mon, Jan 2 2006 15:04:05 GMT"), s.Header())
+}
 
-		for _, v := range pkts {
-			rtpPrefix[0] = 0x24
-			rtpPrefix[1] = byte(c.RtpChannel)
+func (c *ClientConnection) setupRecord(req *RequestInfo, s *RtspSession) string {
+	cseq := req.Headers["CSeq"]
+	track := s.live.findTrack(req.URL, len(s.recordTransports))
+	if track < 0 {
+		return c.handleCmdNOTFOUND(cseq)
+	}
+	ts := req.Headers["Transport"] /*Transport: RTP/AVP/TCP;unicast;interleaved=0-1;mode=record*/
+	interleaved, clientPorts := parseTransport(ts)
+	if !c.rtsp.transportAllowed(transportProtocol(ts, interleaved)) {
+		return c.handleCmdERROR(cseq, "461 Unsupported Transport")
+	}
+	var t *RtpTransport
+	if interleaved != nil {
+		t = NewTCPTransport(c, interleaved[0], interleaved[1])
+	} else if clientPorts != nil && !strings.Contains(ts, "multicast") && !c.secure() {
+		var err error
+		if t, err = NewUDPTransport(c, remoteIP(c.Conn), clientPorts[0], clientPorts[1]); err != nil {
+			log.Println(err)
+			return c.handleCmdERROR(cseq, "453 Not Enough Bandwidth")
+		}
+		t.onRTP = func(pkt []byte) {
+			s.writeRecorded(track, pkt, false)
+		}
+		t.onRTCP = func(pkt []byte) {
+			s.writeRecorded(track, pkt, true)
+		}
+	} else {
+		return c.handleCmdERROR(cseq, "461 Unsupported Transport")
+	}
+	s.setRecordTransport(track, t)
+	return c.handleCmdSETUP(cseq, t.String()+";mode=record", s)
+}
 
-			binary.BigEndian.PutUint16(rtpPrefix[2:], uint16(len(v)))
-			c.ConnRW.Write(rtpPrefix)
-			if _, err := c.ConnRW.WriteString(v); err != nil {
-				fmt.Println(err)
-				return
-			} else {
-				c.ConnRW.Flush()
+/*server initiated TEARDOWN, clients that do not know it still see the connection close*/
+func (c *ClientConnection) sendTeardown(mountPath string, s *RtspSession) error {
+	scheme := "rtsp"
+	if c.secure() {
+		scheme = "rtsps"
+	}
+	return c.writeResponse(fmt.Sprintf("TEARDOWN %s://%s%s RTSP/1.0\r\nCSeq: %d\r\nSession: %s\r\n\r\n",
+		scheme, c.Conn.LocalAddr(), mountPath, atomic.AddInt32(&c.cseq, 1), s.ID))
+}
+
+func (c *ClientConnection) writeResponse(resp string) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if _, err := c.ConnRW.WriteString(resp); err != nil {
+		return err
+	}
+	return c.ConnRW.Flush()
+}
+
+/*$ channel size data*/
+func (c *ClientConnection) writeInterleaved(channel int, data string) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	rtpPrefix := []byte{0x24, byte(channel), 0, 0}
+	binary.BigEndian.PutUint16(rtpPrefix[2:], uint16(len(data)))
+	c.ConnRW.Write(rtpPrefix)
+	if _, err := c.ConnRW.WriteString(data); err != nil {
+		return err
+	}
+	return c.ConnRW.Flush()
+}
+
+func (c *ClientConnection) handleCmdOPTIONS(cseq string) string {
+	return fmt.Sprintf("RTSP/1.0 200 OK\r\nCSeq: %s\r\nDate: %s\r\nPublic: %s\r\n\r\n", cseq,
+		time.Now().Format("Mon, Jan 2 2006 15:04:05 GMT"), allowedCommandNames)
+}
+
+func (c *ClientConnection) handleCmdGETPARAMETER(cseq string, s *RtspSession) string {
+	if s == nil {
+		return fmt.Sprintf("RTSP/1.0 200 OK\r\nCSeq: %s\r\nDate: %s\r\n\r\n", cseq,
+			time.Now().Format("Mon, Jan 2 2006 15:04:05 GMT"))
+	}
+	return fmt.Sprintf("RTSP/1.0 200 OK\r\nCSeq: %s\r\nDate: %s\r\nSession: %s\r\n\r\n", cseq,
+		time.Now().Format("Mon, Jan 2 2006 15:04:05 GMT"), s.Header())
+}
+
+func (c *ClientConnection) handleCmdDESCRIBE(cseq string, url string) string {
+	var media string
+	duration := 0.0
+	if live := c.stream.Live(); live != nil {
+		/*relay the publisher's own payload types and parameters*/
+		for i, t := range live.Tracks {
+			media += fmt.Sprintf("m=%s 0 RTP/AVP %s\r\na=rtpmap:%s\r\n", t.MediaType, t.PayloadType, t.RtpMap)
+			if t.Fmtp != "" {
+				media += fmt.Sprintf("a=fmtp:%s\r\n", t.Fmtp)
 			}
+			media += fmt.Sprintf("a=control:trackID=%d\r\n", i)
 		}
-		if (nalu[0]&0x1f) != 6 && (nalu[0]&0x1f) != 7 && (nalu[0]&0x1f) != 8 {
-			pts += 40
-			time.Sleep(time.Millisecond * 30)
+	} else {
+		for i := range c.stream.FileTracks() {
+			info, err := c.stream.TrackInfo(i)
+			if err != nil {
+				log.Println(err)
+				return c.handleCmdNOTFOUND(cseq)
+			}
+			media += info.mediaSDP(fmt.Sprintf("trackID=%d", i))
 		}
+		duration = c.stream.Duration()
+	}
+	sdp := buildSDP(localIP(c.Conn), c.stream.Path, duration, media)
+
+	return fmt.Sprintf("RTSP/1.0 200 OK\r\nCSeq: %s\r\nDate: %s\r\nContent-Base: %s/\r\nContent-Type: application/sdp\r\nContent-Length: %d\r\n\r\n%s", cseq,
+		time.Now().Format("Mon, Jan 2 2006 15:04:05 GMT"), strings.TrimSuffix(url, "/"), len(sdp), sdp)
+}
+
+func (c *ClientConnection) handleCmdSETUP(cseq string, transport string, s *RtspSession) string {
+	return fmt.Sprintf("RTSP/1.0 200 OK\r\nCSeq: %s\r\nDate: %s\r\nTransport: %s\r\nSession: %s\r\n\r\n", cseq,
+		time.Now().Format("Mon, Jan 2 2006 15:04:05 GMT"), transport, s.Header())
+}
+
+/*fast forward up to 4x, backwards (keyframes only) when there is a video track; live mounts only play at 1*/
+func acceptScale(value string, s *RtspSession) float64 {
+	scale, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || scale == 0 || s.stream.IsLive() {
+		return 1
+	}
+	scale = math.Max(-4, math.Min(4, scale))
+	if scale < 0 && !s.hasVideo() {
+		return 1
+	}
+	return scale
+}
+
+func acceptSpeed(value string, s *RtspSession) float64 {
+	speed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || speed <= 0 || s.stream.IsLive() {
+		return 1
+	}
+	return math.Min(4, speed)
+}
 
+func (c *ClientConnection) handleCmdPLAY(cseq string, url string, s *RtspSession, rate string) string {
+	rng := "Range: npt=0.000-\r\n"
+	rtpInfo := ""
+	if npt, end, infos := s.playPosition(strings.TrimSuffix(url, "/")); infos != "" {
+		scale, _ := s.rate()
+		if end <= 0 && scale > 0 {
+			end = s.stream.Duration()
+		}
+		if end > 0 || scale < 0 {
+			rng = fmt.Sprintf("Range: npt=%.3f-%.3f\r\n", npt, end)
+		} else { /*looping mounts have no end*/
+			rng = fmt.Sprintf("Range: npt=%.3f-\r\n", npt)
+		}
+		rtpInfo = fmt.Sprintf("RTP-Info: %s\r\n", infos)
 	}
+	return fmt.Sprintf("RTSP/1.0 200 OK\r\nCSeq: %s\r\nDate: %s\r\n%s%sSession: %s\r\n%s\r\n", cseq,
+		time.Now().Format("Mon, Jan 2 2006 15:04:05 GMT"), rng, rate, s.Header(), rtpInfo)
+}
+
+func (c *ClientConnection) handleCmdPAUSE(cseq string, s *RtspSession) string {
+	return fmt.Sprintf("RTSP/1.0 200 OK\r\nCSeq: %s\r\nDate: %s\r\nSession: %s\r\n\r\n", cseq,
+		time.Now().Format("Mon, Jan 2 2006 15:04:05 GMT"), s.Header())
+}
+
+func (c *ClientConnection) handleCmdRECORD(cseq string, s *RtspSession) string {
+	return fmt.Sprintf("RTSP/1.0 200 OK\r\nCSeq: %s\r\nDate: %s\r\nSession: %s\r\n\r\n", cseq,
+		time.Now().Format("Mon, Jan 2 2006 15:04:05 GMT"), s.Header())
+}
+
+func (c *ClientConnection) handleCmdTEARDOWN(cseq string, s *RtspSession) string {
+	return fmt.Sprintf("RTSP/1.0 200 OK\r\nCSeq: %s\r\nDate: %s\r\nSession: %s\r\nConnection: Close\r\n\r\n", cseq,
+		time.Now().Format("Mon, Jan 2 2006 15:04:05 GMT"), s.ID)
+}
+
+func (c *ClientConnection) handleCmdNOTFOUND(cseq string) string {
+	return fmt.Sprintf("RTSP/1.0 404 Stream Not Found\r\nCSeq: %s\r\nDate: %s\r\n\r\n", cseq, time.Now().Format("Mon, Jan 2 2006 15:04:05 GMT"))
+}
+
+func (c *ClientConnection) handleCmdUNAUTHORIZED(cseq string, stale bool) string {
+	return fmt.Sprintf("RTSP/1.0 401 Unauthorized\r\nCSeq: %s\r\nDate: %s\r\n%s\r\n", cseq,
+		time.Now().Format("Mon, Jan 2 2006 15:04:05 GMT"), c.rtsp.auth.Challenge(stale))
+}
+
+func (c *ClientConnection) handleCmdERROR(cseq string, status string) string {
+	return fmt.Sprintf("RTSP/1.0 %s\r\nCSeq: %s\r\nDate: %s\r\n\r\n", status, cseq, time.Now().Format("Mon, Jan 2 2006 15:04:05 GMT"))
 }
@@ -0,0 +1,99 @@
+// client-connection_test
+package rtsp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/*two frames of annex b h264, enough for a player to open*/
+func writeH264File(t *testing.T) string {
+	name := filepath.Join(t.TempDir(), "test.h264")
+	var data []byte
+	data = append(data, 0, 0, 0, 1, 0x67, 0x42, 0xc0, 0x1e, 0xda, 0x02, 0x80, 0xbf, 0xe5, 0x84)
+	data = append(data, 0, 0, 0, 1, 0x68, 0xce, 0x3c, 0x80)
+	data = append(data, 0, 0, 0, 1, 0x65, 0x88)
+	data = append(data, make([]byte, 100)...)
+	data = append(data, 0, 0, 0, 1, 0x41, 0x9a, 0x11, 0x11)
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func testSession(t *testing.T, cfg *MountConfig, video bool) *RtspSession {
+	s := &RtspSession{
+		stream:  NewMediaStream(cfg),
+		players: make(map[int]*FilePlayer),
+	}
+	if video {
+		p, err := NewFilePlayer(cfg.File, nil, 25)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.players[0] = p
+	}
+	return s
+}
+
+func TestAcceptScale(t *testing.T) {
+	file := writeH264File(t)
+	video := testSession(t, &MountConfig{Path: "/vod", File: file}, true)
+	noVideo := testSession(t, &MountConfig{Path: "/vod", File: file}, false)
+	live := testSession(t, &MountConfig{Path: "/live", Publish: true}, false)
+	tests := []struct {
+		name  string
+		value string
+		s     *RtspSession
+		want  float64
+	}{
+		{"normal", "1", video, 1},
+		{"fast forward", "2", video, 2},
+		{"slow motion", "0.5", video, 0.5},
+		{"spaces", " 1.5 ", video, 1.5},
+		{"capped", "8", video, 4},
+		{"backwards", "-2", video, -2},
+		{"backwards capped", "-16", video, -4},
+		{"zero", "0", video, 1},
+		{"garbage", "fast", video, 1},
+		{"backwards without video", "-1", noVideo, 1},
+		{"forward without video", "2", noVideo, 2},
+		{"live", "2", live, 1},
+		{"live backwards", "-1", live, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := acceptScale(tt.value, tt.s); got != tt.want {
+				t.Errorf("scale %q -> %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAcceptSpeed(t *testing.T) {
+	file := testSession(t, &MountConfig{Path: "/vod", File: "unused.h264"}, false)
+	live := testSession(t, &MountConfig{Path: "/live", Publish: true}, false)
+	tests := []struct {
+		name  string
+		value string
+		s     *RtspSession
+		want  float64
+	}{
+		{"normal", "1", file, 1},
+		{"faster", "2.5", file, 2.5},
+		{"slower", "0.5", file, 0.5},
+		{"capped", "10", file, 4},
+		{"zero", "0", file, 1},
+		{"negative", "-2", file, 1},
+		{"garbage", "x", file, 1},
+		{"live", "2", live, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := acceptSpeed(tt.value, tt.s); got != tt.want {
+				t.Errorf("speed %q -> %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,439 @@
+// config
+package rtsp
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+/*everything the config file can hold, timeouts are in seconds*/
+type ServerConfig struct {
+	Host             string        `mapstructure:"host"`
+	Port             int           `mapstructure:"port"`
+	HttpPort         int           `mapstructure:"http_port"`
+	TLSPort          int           `mapstructure:"tls_port"`
+	TLSCert          string        `mapstructure:"tls_cert"`
+	TLSKey           string        `mapstructure:"tls_key"`
+	AdminAddr        string        `mapstructure:"admin_addr"`
+	AdminToken       string        `mapstructure:"admin_token"`
+	HookUrl          string        `mapstructure:"hook_url"`
+	HookTimeout      int           `mapstructure:"hook_timeout"`
+	Transports       []string      `mapstructure:"transports"` /*tcp, udp, multicast; empty allows all*/
+	RtpPortMin       int           `mapstructure:"rtp_port_min"`
+	RtpPortMax       int           `mapstructure:"rtp_port_max"`
+	MulticastBase    string        `mapstructure:"multicast_base"`
+	MulticastTTL     int           `mapstructure:"multicast_ttl"`
+	MaxConnections   int           `mapstructure:"max_connections"` /*0 for no limit*/
+	MaxSessions      int           `mapstructure:"max_sessions"`    /*0 for no limit*/
+	DynamicMounts    bool          `mapstructure:"dynamic_mounts"`
+	SessionTimeout   int           `mapstructure:"session_timeout"`
+	GopCacheSize     int           `mapstructure:"gop_cache_size"`
+	IdleTimeout      int           `mapstructure:"idle_timeout"`
+	FrameRate        float64       `mapstructure:"frame_rate"`
+	AuthRealm        string        `mapstructure:"auth_realm"`
+	AuthBasic        bool          `mapstructure:"auth_basic"`
+	AuthNonceTimeout int           `mapstructure:"auth_nonce_timeout"`
+	Users            []UserConfig  `mapstructure:"users"`
+	Mounts           []MountConfig `mapstructure:"mounts"`
+}
+
+/*defaults are the ones of a new server, so a key deleted from the file goes back to its default on reload*/
+func (r *RtspServer) newViper() *viper.Viper {
+	v := viper.New()
+	if r.ConfigFile != "" {
+		v.SetConfigFile(r.ConfigFile)
+	} else {
+		v.SetConfigName("config")
+		v.SetConfigType("json")
+		v.AddConfigPath(".")
+	}
+	d := NewRtspServer()
+	v.SetDefault("host", d.Host)
+	v.SetDefault("port", d.Port)
+	v.SetDefault("http_port", d.HttpPort)
+	v.SetDefault("tls_port", d.TLSPort)
+	v.SetDefault("tls_cert", d.TLSCert)
+	v.SetDefault("tls_key", d.TLSKey)
+	v.SetDefault("admin_addr", d.AdminAddr)
+	v.SetDefault("admin_token", d.AdminToken)
+	v.SetDefault("hook_url", d.HookUrl)
+	v.SetDefault("hook_timeout", int(d.HookTimeout/time.Second))
+	v.SetDefault("transports", []string{})
+	v.SetDefault("rtp_port_min", d.RtpPortMin)
+	v.SetDefault("rtp_port_max", d.RtpPortMax)
+	v.SetDefault("multicast_base", d.MulticastBase)
+	v.SetDefault("multicast_ttl", d.MulticastTTL)
+	v.SetDefault("max_connections", d.MaxConns)
+	v.SetDefault("max_sessions", d.MaxSessions)
+	v.SetDefault("dynamic_mounts", d.DynamicMounts)
+	v.SetDefault("session_timeout", int(d.sessions.Timeout/time.Second))
+	v.SetDefault("gop_cache_size", d.GopCacheSize)
+	v.SetDefault("idle_timeout", int(d.IdleTimeout/time.Second))
+	v.SetDefault("frame_rate", d.FrameRate)
+	v.SetDefault("auth_realm", d.auth.Realm)
+	v.SetDefault("auth_basic", d.auth.Basic)
+	v.SetDefault("auth_nonce_timeout", int(d.auth.NonceTimeout/time.Second))
+	v.SetDefault("users", []UserConfig{})
+	v.SetDefault("mounts", []MountConfig{})
+	return v
+}
+
+/*read and check the config file, unknown keys are errors so typos do not go unnoticed*/
+func (r *RtspServer) readConfig() (*ServerConfig, error) {
+	v := r.newViper()
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	var cfg ServerConfig
+	var problems []string
+	if err := v.UnmarshalExact(&cfg); err != nil {
+		/*unknown keys still leave the known ones decoded, check those too*/
+		cfg = ServerConfig{}
+		if err := v.Unmarshal(&cfg); err != nil {
+			return nil, fmt.Errorf("%s: %v", v.ConfigFileUsed(), err)
+		}
+		problems = append(problems, strings.TrimSpace(err.Error()))
+	}
+	if err := cfg.Validate(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%s: %s", v.ConfigFileUsed(), strings.Join(problems, "\n"))
+	}
+	return &cfg, nil
+}
+
+func validPort(port int, zeroOk bool) bool {
+	return (zeroOk && port == 0) || (port > 0 && port < 65536)
+}
+
+func fileExists(name string) bool {
+	fi, err := os.Stat(name)
+	return err == nil && !fi.IsDir()
+}
+
+/*every problem at once, one per line*/
+func (c *ServerConfig) Validate() error {
+	var problems []string
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if !validPort(c.Port, false) {
+		fail("port %d is not a valid port", c.Port)
+	}
+	if !validPort(c.HttpPort, true) {
+		fail("http_port %d is not a valid port", c.HttpPort)
+	}
+	ports := map[int]string{c.Port: "port"}
+	if c.HttpPort != 0 {
+		if other, ok := ports[c.HttpPort]; ok {
+			fail("http_port %d is already taken by %s", c.HttpPort, other)
+		}
+		ports[c.HttpPort] = "http_port"
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		fail("tls_cert and tls_key go together")
+	}
+	if c.TLSCert != "" && c.TLSKey != "" {
+		if !validPort(c.TLSPort, false) {
+			fail("tls_port %d is not a valid port", c.TLSPort)
+		} else if other, ok := ports[c.TLSPort]; ok {
+			fail("tls_port %d is already taken by %s", c.TLSPort, other)
+		}
+		for _, name := range []string{c.TLSCert, c.TLSKey} {
+			if !fileExists(name) {
+				fail("tls file %s not found", name)
+			}
+		}
+	}
+	if c.AdminAddr != "" {
+		if _, _, err := net.SplitHostPort(c.AdminAddr); err != nil {
+			fail("admin_addr: %v", err)
+		}
+	}
+	if c.HookUrl != "" {
+		if u, err := url.Parse(c.HookUrl); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("hook_url %q is not an http(s) url", c.HookUrl)
+		}
+		if c.HookTimeout == 0 { /*the hook would be waited for forever*/
+			fail("hook_timeout must be at least 1 with a hook_url")
+		}
+	}
+	for _, t := range c.Transports {
+		if t != "tcp" && t != "udp" && t != "multicast" {
+			fail("transport %q is none of tcp, udp, multicast", t)
+		}
+	}
+	if !validPort(c.RtpPortMin, false) || !validPort(c.RtpPortMax, false) || c.RtpPortMax-(c.RtpPortMin&^1) < 1 {
+		fail("rtp_port_min %d and rtp_port_max %d do not make a port range", c.RtpPortMin, c.RtpPortMax)
+	}
+	if ip := net.ParseIP(c.MulticastBase); ip == nil || ip.To4() == nil || !ip.IsMulticast() {
+		fail("multicast_base %q is not an ipv4 multicast address", c.MulticastBase)
+	}
+	if c.MulticastTTL < 1 || c.MulticastTTL > 255 {
+		fail("multicast_ttl %d is not within 1-255", c.MulticastTTL)
+	}
+	for _, n := range []struct {
+		key   string
+		value int
+	}{{"hook_timeout", c.HookTimeout}, {"max_connections", c.MaxConnections}, {"max_sessions", c.MaxSessions},
+		{"session_timeout", c.SessionTimeout}, {"idle_timeout", c.IdleTimeout}, {"auth_nonce_timeout", c.AuthNonceTimeout}} {
+		if n.value < 0 {
+			fail("%s %d is negative", n.key, n.value)
+		}
+	}
+	if c.FrameRate < 0 {
+		fail("frame_rate %g is negative", c.FrameRate)
+	}
+
+	users := make(map[string]bool)
+	for i, u := range c.Users {
+		switch {
+		case u.UserName == "" || strings.Contains(u.UserName, ":"):
+			fail("users[%d]: username %q is empty or has a colon", i, u.UserName)
+		case users[u.UserName]:
+			fail("users[%d]: %s is listed twice", i, u.UserName)
+		case u.Password == "":
+			fail("users[%d]: %s has no password", i, u.UserName)
+		}
+		users[u.UserName] = true
+	}
+
+	mounts := make(map[string]bool)
+	for i, m := range c.Mounts {
+		if strings.Trim(m.Path, "/ ") == "" {
+			fail("mounts[%d]: no path", i)
+			continue
+		}
+		name := fmt.Sprintf("mounts[%d] %s", i, m.Path)
+		if p := normalizeMountPath(m.Path); mounts[p] {
+			fail("%s: path is listed twice", name)
+		} else {
+			mounts[p] = true
+		}
+		for _, problem := range m.problems() {
+			fail("%s: %s", name, problem)
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New("invalid config:\n\t" + strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
+
+/*everything wrong with one mount but its path, for the config file and the admin api alike*/
+func (m *MountConfig) problems() []string {
+	var problems []string
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	files := append([]string{}, m.Playlist...)
+	for _, f := range []string{m.File, m.Audio} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	kinds := 0
+	for _, is := range []bool{len(files) > 0, m.Source != "", m.Publish} {
+		if is {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		fail("needs exactly one of file/playlist/audio, source or publish")
+	}
+	for _, f := range files {
+		if !fileExists(f) {
+			fail("file %s not found", f)
+		}
+	}
+	if m.Source != "" {
+		if u, err := url.Parse(m.Source); err != nil || u.Scheme != "rtsp" || u.Host == "" {
+			fail("source is not an rtsp url")
+		}
+	}
+	if m.Loop && len(files) == 0 {
+		fail("loop needs files")
+	}
+	if m.MulticastGroup != "" {
+		if ip := net.ParseIP(m.MulticastGroup); ip == nil || ip.To4() == nil || !ip.IsMulticast() {
+			fail("multicast_group %q is not an ipv4 multicast address", m.MulticastGroup)
+		}
+	}
+	if !validPort(m.MulticastPort, true) {
+		fail("multicast_port %d is not a valid port", m.MulticastPort)
+	}
+	if m.MulticastTTL < 0 || m.MulticastTTL > 255 {
+		fail("multicast_ttl %d is not within 0-255", m.MulticastTTL)
+	}
+	if m.IdleTimeout < 0 || m.FrameRate < 0 {
+		fail("idle_timeout and frame_rate cannot be negative")
+	}
+	return problems
+}
+
+func (r *RtspServer) GetConfig() error {
+	cfg, err := r.readConfig()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if err := r.applyConfig(cfg, true); err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+/*read the config file again; a config that does not pass validation is rejected as a whole and the running one stays*/
+func (r *RtspServer) Reload() error {
+	cfg, err := r.readConfig()
+	if err != nil {
+		log.Println("config rejected:", err)
+		return err
+	}
+	log.Println("reload config")
+	return r.applyConfig(cfg, false)
+}
+
+/*reload whenever the config file changes*/
+func (r *RtspServer) WatchConfig() {
+	v := r.newViper()
+	if err := v.ReadInConfig(); err != nil {
+		log.Println(err)
+		return
+	}
+	v.OnConfigChange(func(e fsnotify.Event) {
+		r.Reload()
+	})
+	v.WatchConfig()
+}
+
+/*
+listeners, realm and multicast ttl are taken at start only, a reload just warns about them.
+mounts are diffed against the last config: only added, removed or changed mounts are touched,
+mounts made by ANNOUNCE or the admin api are left alone
+*/
+func (r *RtspServer) applyConfig(cfg *ServerConfig, initial bool) error {
+	r.reloadLock.Lock()
+	defer r.reloadLock.Unlock()
+
+	realm, basic, nonceTimeout := r.auth.settings()
+	startOnly := []struct {
+		key     string
+		current interface{}
+		next    interface{}
+		set     func()
+	}{
+		{"host", r.Host, cfg.Host, func() { r.Host = cfg.Host }},
+		{"port", r.Port, uint16(cfg.Port), func() { r.Port = uint16(cfg.Port) }},
+		{"http_port", r.HttpPort, uint16(cfg.HttpPort), func() { r.HttpPort = uint16(cfg.HttpPort) }},
+		{"tls_port", r.TLSPort, uint16(cfg.TLSPort), func() { r.TLSPort = uint16(cfg.TLSPort) }},
+		{"tls_cert", r.TLSCert, cfg.TLSCert, func() { r.TLSCert = cfg.TLSCert }},
+		{"tls_key", r.TLSKey, cfg.TLSKey, func() { r.TLSKey = cfg.TLSKey }},
+		{"admin_addr", r.AdminAddr, cfg.AdminAddr, func() { r.AdminAddr = cfg.AdminAddr }},
+		{"admin_token", r.AdminToken, cfg.AdminToken, func() { r.AdminToken = cfg.AdminToken }},
+		{"multicast_ttl", r.MulticastTTL, cfg.MulticastTTL, func() { r.MulticastTTL = cfg.MulticastTTL }},
+		/*auth settings are set together by Configure below*/
+		{"auth_realm", realm, cfg.AuthRealm, nil},
+		{"auth_basic", basic, cfg.AuthBasic, nil},
+		{"auth_nonce_timeout", nonceTimeout, time.Second * time.Duration(cfg.AuthNonceTimeout), nil},
+	}
+	for _, s := range startOnly {
+		if initial {
+			if s.set != nil {
+				s.set()
+			}
+		} else if s.current != s.next {
+			log.Printf("%s changed, it takes a restart to apply\n", s.key)
+		}
+	}
+	if initial {
+		r.auth.Configure(cfg.AuthRealm, cfg.AuthBasic, time.Second*time.Duration(cfg.AuthNonceTimeout))
+	}
+
+	r.hookLock.Lock()
+	r.HookUrl = cfg.HookUrl
+	r.HookTimeout = time.Second * time.Duration(cfg.HookTimeout)
+	r.hookLock.Unlock()
+
+	r.connLock.Lock()
+	r.MaxConns, r.MaxSessions = cfg.MaxConnections, cfg.MaxSessions
+	r.Transports = cfg.Transports
+	r.connLock.Unlock()
+
+	r.portLock.Lock()
+	r.RtpPortMin, r.RtpPortMax = uint16(cfg.RtpPortMin), uint16(cfg.RtpPortMax)
+	r.MulticastBase = cfg.MulticastBase
+	r.portLock.Unlock()
+
+	r.streamLock.Lock()
+	r.GopCacheSize = cfg.GopCacheSize
+	r.DynamicMounts = cfg.DynamicMounts
+	if cfg.FrameRate > 0 {
+		r.FrameRate = cfg.FrameRate
+	}
+	if cfg.IdleTimeout > 0 {
+		r.IdleTimeout = time.Second * time.Duration(cfg.IdleTimeout)
+	}
+	r.streamLock.Unlock()
+	if cfg.SessionTimeout > 0 {
+		r.sessions.SetTimeout(time.Second * time.Duration(cfg.SessionTimeout))
+	}
+
+	users := make(map[string]string)
+	for _, u := range cfg.Users {
+		users[u.UserName] = u.Password
+	}
+	r.auth.SetUsers(users)
+
+	mounts := make(map[string]MountConfig)
+	for _, m := range cfg.Mounts {
+		mounts[normalizeMountPath(m.Path)] = m
+	}
+	for p, old := range r.mounts {
+		if m, ok := mounts[p]; !ok || !reflect.DeepEqual(m, old) {
+			r.dropStream(p)
+			delete(r.mounts, p)
+		}
+	}
+	var err error
+	for p, m := range mounts {
+		if _, ok := r.mounts[p]; ok {
+			continue
+		}
+		if err = r.AddStream(NewMediaStream(&m)); err != nil {
+			log.Println(err)
+			continue
+		}
+		r.mounts[p] = m
+	}
+	if initial {
+		return err
+	}
+	return nil
+}
+
+/*remove a mount and end the sessions on it*/
+func (r *RtspServer) dropStream(mountPath string) *MediaStream {
+	s := r.RemoveStream(mountPath)
+	if s == nil {
+		return nil
+	}
+	for _, session := range r.streamSessions(s) {
+		session.shutdown()
+	}
+	log.Printf("mount %s removed\n", s.Path)
+	return s
+}
@@ -0,0 +1,382 @@
+// file-player
+package rtsp
+
+import (
+	"encoding/binary"
+	"log"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+/*one elementary stream file, read frame by frame*/
+type frameReader interface {
+	ClockRate() uint32
+	PayloadType() byte
+	/*rtp packets of the next frame and its duration in clock ticks, nil at the end of the file*/
+	NextFrame(rtp *RtpPacket, timestamp uint32) ([]string, uint32)
+	/*move to the random access point at or before timestamp and return its timestamp*/
+	Seek(timestamp uint32) uint32
+}
+
+/*h264/h265 access units, each lasting the frame period of the last parameter set with timing info, or the mount's frame rate*/
+type videoReader struct {
+	source        *MediaFileSource
+	index         []seekPoint
+	fallback      uint32 /*frame period in clock ticks when the stream signals none*/
+	frameDuration uint32
+}
+
+func newVideoReader(source *MediaFileSource, frameRate float64) *videoReader {
+	fallback := frameTicks(frameRate)
+	return &videoReader{
+		source:        source,
+		fallback:      fallback,
+		frameDuration: fallback,
+	}
+}
+
+/*90kHz ticks per frame, 25fps when the rate is unknown*/
+func frameTicks(frameRate float64) uint32 {
+	if frameRate <= 0 {
+		frameRate = 25
+	}
+	return uint32(90000/frameRate + 0.5)
+}
+
+/*vps/sps timing info changes the frame period from this access unit on*/
+func (v *videoReader) observe(nalu []byte) {
+	if rate := paramSetFrameRate(v.source.Codec, nalu); rate > 0 && rate <= 300 {
+		v.frameDuration = frameTicks(rate)
+	}
+}
+
+type seekPoint struct {
+	offset    uint32
+	timestamp uint32
+}
+
+func (v *videoReader) ClockRate() uint32 {
+	return 90000
+}
+
+func (v *videoReader) PayloadType() byte {
+	return 0x60
+}
+
+func (v *videoReader) NextFrame(rtp *RtpPacket, timestamp uint32) ([]string, uint32) {
+	var pkts []string
+	for {
+		nalu := v.source.GetNextNalu()
+		if nalu == nil {
+			if len(pkts) > 0 {
+				return pkts, v.frameDuration
+			}
+			return nil, 0
+		}
+		if len(nalu) == 0 {
+			continue
+		}
+		v.observe(nalu)
+		mark := isFrameEnd(v.source.Codec, nalu, v.source.PeekNextNalu())
+		if v.source.Codec == CodecH265 {
+			pkts = append(pkts, rtp.BuildRTPWithHEVCNALUTimestamp(mark, nalu, timestamp)...)
+		} else {
+			pkts = append(pkts, rtp.BuildRTPWithAVCNALUTimestamp(mark, nalu, timestamp)...)
+		}
+		if mark {
+			return pkts, v.frameDuration
+		}
+	}
+}
+
+func (v *videoReader) Seek(timestamp uint32) uint32 {
+	if v.index == nil {
+		v.index = v.buildIndex()
+	}
+	point := seekPoint{offset: 0, timestamp: 0}
+	for _, k := range v.index {
+		if k.timestamp > timestamp {
+			break
+		}
+		point = k
+	}
+	v.source.Offset = point.offset
+	return point.timestamp
+}
+
+/*move to the last random access point before bound, false when there is none*/
+func (v *videoReader) SeekBefore(bound uint32) (uint32, bool) {
+	if v.index == nil {
+		v.index = v.buildIndex()
+	}
+	for i := len(v.index) - 1; i >= 0; i-- {
+		if v.index[i].timestamp < bound {
+			v.source.Offset = v.index[i].offset
+			return v.index[i].timestamp, true
+		}
+	}
+	return 0, false
+}
+
+/*start of every access unit holding parameter sets or an idr/irap slice*/
+func (v *videoReader) buildIndex() []seekPoint {
+	src := &MediaFileSource{FileSize: v.source.FileSize, Codec: v.source.Codec, data: v.source.data}
+	scan := &videoReader{source: src, fallback: v.fallback, frameDuration: v.fallback}
+	index := []seekPoint{}
+	timestamp := uint32(0)
+	start, key := src.Offset, false
+	for nalu := src.GetNextNalu(); nalu != nil; nalu = src.GetNextNalu() {
+		if len(nalu) == 0 {
+			continue
+		}
+		scan.observe(nalu)
+		if isKeyFramePayload(src.Codec, nalu) {
+			key = true
+		}
+		if isFrameEnd(src.Codec, nalu, src.PeekNextNalu()) {
+			if key {
+				index = append(index, seekPoint{offset: start, timestamp: timestamp})
+			}
+			timestamp += scan.frameDuration
+			start, key = src.Offset, false
+		}
+	}
+	return index
+}
+
+func openFrameReader(fileName string, frameRate float64) (frameReader, error) {
+	switch audioCodec(fileName) {
+	case CodecAAC:
+		a, err := NewAACFileSource(fileName)
+		if err != nil {
+			return nil, err
+		}
+		return a, nil
+	case CodecPCMU, CodecPCMA:
+		g, err := NewG711FileSource(fileName)
+		if err != nil {
+			return nil, err
+		}
+		return g, nil
+	}
+	mf := NewMediaFileSource()
+	if err := mf.ReadFileData(fileName); err != nil {
+		return nil, err
+	}
+	return newVideoReader(mf, frameRate), nil
+}
+
+/*packetizes a media file onto a transport, Pause keeps the read position, seq and timestamp; Scale and Speed as in SetRate*/
+type FilePlayer struct {
+	FileName   string
+	transport  *RtpTransport
+	reader     *playlistReader
+	rtp        *RtpPacket
+	rtcp       *RTCPPacket
+	timestamp  uint32   /*of the next frame, in clock ticks from the start of the playlist*/
+	clock      uint32   /*rtp timestamp of the next frame, the file timestamp divided by the scale*/
+	bound      uint32   /*playing backwards, the next frame is before this timestamp*/
+	pending    []string /*next frame, already packetized*/
+	duration   uint32
+	end        uint32 /*stop at this timestamp, 0 plays to the end (or the start) of the file*/
+	scale      float64
+	speed      float64
+	start      time.Time
+	lastReport time.Time
+	quit       chan struct{}
+	done       chan struct{}
+	lock       sync.Mutex
+	stateLock  sync.Mutex
+}
+
+/*frameRate is the fallback for video files that do not signal one*/
+func NewFilePlayer(fileName string, transport *RtpTransport, frameRate float64) (*FilePlayer, error) {
+	return NewPlaylistPlayer(&Playlist{Files: []string{fileName}}, transport, frameRate)
+}
+
+/*one rtp stream over every file of the list, seq and timestamps run on across files*/
+func NewPlaylistPlayer(list *Playlist, transport *RtpTransport, frameRate float64) (*FilePlayer, error) {
+	reader, err := newPlaylistReader(list, frameRate)
+	if err != nil {
+		return nil, err
+	}
+	rtp := NewRtpPacket(0, rand.Uint32(), reader.PayloadType())
+	rtcp := NewRTCP(0)
+	rtcp.SenderSSRC = rtp.ssrc
+	return &FilePlayer{
+		FileName:  list.Files[0],
+		transport: transport,
+		reader:    reader,
+		rtp:       rtp,
+		rtcp:      rtcp,
+		timestamp: 0,
+		clock:     0,
+		scale:     1,
+		speed:     1,
+	}, nil
+}
+
+func (p *FilePlayer) Play() {
+	p.PlayAt(time.Now())
+}
+
+/*start, or resume from where Pause left off; players started with the same time stay in sync*/
+func (p *FilePlayer) PlayAt(now time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.quit != nil {
+		return
+	}
+	p.stateLock.Lock()
+	p.start = now.Add(-p.wall(p.clock))
+	p.stateLock.Unlock()
+	p.quit = make(chan struct{})
+	p.done = make(chan struct{})
+	go p.run(p.quit, p.done)
+}
+
+func (p *FilePlayer) Pause() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.quit == nil {
+		return
+	}
+	close(p.quit)
+	<-p.done
+	p.quit = nil
+}
+
+/*run returned on its own: end of file or range, or a failed write*/
+func (p *FilePlayer) Finished() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.quit == nil {
+		return false
+	}
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *FilePlayer) Stop() {
+	p.Pause()
+}
+
+/*seq and rtptime of the next packet, and its npt*/
+func (p *FilePlayer) Position() (seq uint16, rtpTime uint32, npt float64) {
+	p.stateLock.Lock()
+	defer p.stateLock.Unlock()
+	seq = p.rtp.seq
+	if len(p.pending) > 0 {
+		seq = binary.BigEndian.Uint16([]byte(p.pending[0][2:4]))
+	}
+	return seq, p.clock, p.elapsed(p.timestamp).Seconds()
+}
+
+/*h264/h265, the only tracks played at a scale other than 1*/
+func (p *FilePlayer) IsVideo() bool {
+	return p.reader.isVideo()
+}
+
+/*scale moves through the file faster, or backwards over random access points only; speed only changes the delivery rate. the player must be paused*/
+func (p *FilePlayer) SetRate(scale float64, speed float64) {
+	p.stateLock.Lock()
+	defer p.stateLock.Unlock()
+	p.scale, p.speed = scale, speed
+}
+
+/*jump to the random access point at or before npt and stop at end (0 for the end of the file), returns the npt actually reached; the player must be paused*/
+func (p *FilePlayer) Seek(npt float64, end float64) float64 {
+	p.stateLock.Lock()
+	defer p.stateLock.Unlock()
+	p.timestamp = p.reader.Seek(p.ticks(npt))
+	p.clock, p.bound = p.timestamp, p.timestamp+1
+	p.pending = nil
+	p.end = 0
+	if end > 0 {
+		p.end = p.ticks(end)
+	}
+	return p.elapsed(p.timestamp).Seconds()
+}
+
+func (p *FilePlayer) ticks(npt float64) uint32 {
+	return uint32(npt * float64(p.reader.ClockRate()))
+}
+
+func (p *FilePlayer) elapsed(timestamp uint32) time.Duration {
+	return time.Duration(uint64(timestamp) * uint64(time.Second) / uint64(p.reader.ClockRate()))
+}
+
+/*wall time for rtp ticks at the current speed*/
+func (p *FilePlayer) wall(clock uint32) time.Duration {
+	return time.Duration(float64(p.elapsed(clock)) / p.speed)
+}
+
+func (p *FilePlayer) scaled(ticks uint32) uint32 {
+	return uint32(float64(ticks) / math.Abs(p.scale))
+}
+
+/*next frame in play order: every frame forwards, random access points only backwards*/
+func (p *FilePlayer) nextFrame() ([]string, uint32) {
+	if p.scale > 0 {
+		return p.reader.NextFrame(p.rtp, p.clock)
+	}
+	timestamp, ok := p.reader.SeekBefore(p.bound)
+	if !ok {
+		return nil, 0
+	}
+	p.clock += p.scaled(p.timestamp - timestamp)
+	p.timestamp, p.bound = timestamp, timestamp
+	pkts, _ := p.reader.NextFrame(p.rtp, p.clock)
+	return pkts, 0
+}
+
+func (p *FilePlayer) run(quit chan struct{}, done chan struct{}) {
+	defer close(done)
+	for {
+		p.stateLock.Lock()
+		if p.pending == nil {
+			p.pending, p.duration = p.nextFrame()
+		}
+		pkts := p.pending
+		due := p.start.Add(p.wall(p.clock))
+		ended := p.end > 0 && ((p.scale > 0 && p.timestamp >= p.end) || (p.scale < 0 && p.timestamp < p.end))
+		p.stateLock.Unlock()
+		if pkts == nil {
+			log.Println("end file")
+			return
+		}
+		if ended {
+			log.Println("end of range")
+			return
+		}
+
+		/*a pause while waiting keeps the frame for the resume*/
+		select {
+		case <-quit:
+			return
+		case <-time.After(time.Until(due)):
+		}
+		for _, v := range pkts {
+			if err := p.transport.WriteRTP(v); err != nil {
+				log.Println(err)
+				return
+			}
+		}
+		if time.Since(p.lastReport) > time.Second*5 {
+			p.lastReport = time.Now()
+			report := p.rtcp.GenerateSR(p.clock, p.transport.PacketsSent, p.transport.OctetsSent)
+			p.transport.WriteRTCP(append(report, p.rtcp.GenerateSD()...))
+		}
+		p.stateLock.Lock()
+		p.timestamp += p.duration
+		p.clock += p.scaled(p.duration)
+		p.pending = nil
+		p.stateLock.Unlock()
+	}
+}
@@ -0,0 +1,110 @@
+// hooks
+package rtsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	HookConnect      = "connect"
+	HookDescribe     = "describe"
+	HookPlay         = "play"
+	HookPublishStart = "publish_start" /*on ANNOUNCE, before the mount is taken*/
+	HookPublishStop  = "publish_stop"
+	HookTeardown     = "teardown"
+	HookDisconnect   = "disconnect"
+)
+
+type HookEvent struct {
+	Event   string    `json:"event"`
+	Mount   string    `json:"mount,omitempty"`
+	Session string    `json:"session,omitempty"`
+	Remote  string    `json:"remote,omitempty"`
+	User    string    `json:"user,omitempty"`
+	Url     string    `json:"url,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+/*an error denies connect, describe, play and publish_start; the other events cannot be denied*/
+type HookFunc func(*HookEvent) error
+
+func (r *RtspServer) AddHook(f HookFunc) {
+	r.hookLock.Lock()
+	defer r.hookLock.Unlock()
+	r.hooks = append(r.hooks, f)
+}
+
+/*callbacks in the order they were added, then the http hook; the first refusal wins*/
+func (r *RtspServer) runHooks(e *HookEvent) error {
+	r.hookLock.Lock()
+	hooks := append([]HookFunc(nil), r.hooks...)
+	hookUrl, timeout := r.HookUrl, r.HookTimeout
+	r.hookLock.Unlock()
+	for _, f := range hooks {
+		if err := f(e); err != nil {
+			return err
+		}
+	}
+	if hookUrl != "" {
+		return postHook(hookUrl, timeout, e)
+	}
+	return nil
+}
+
+/*for events that can be denied, the caller waits*/
+func (r *RtspServer) checkHooks(e *HookEvent) error {
+	err := r.runHooks(e)
+	if err != nil {
+		log.Printf("%s of %s denied: %v\n", e.Event, e.Remote, err)
+	}
+	return err
+}
+
+/*for events that only inform, nobody waits*/
+func (r *RtspServer) notifyHooks(e *HookEvent) {
+	go func() {
+		if err := r.runHooks(e); err != nil {
+			log.Println(err)
+		}
+	}()
+}
+
+/*a 2xx response allows, anything else (an unreachable hook too) denies*/
+func postHook(hookUrl string, timeout time.Duration, e *HookEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Post(hookUrl, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	reason, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("hook answered %s %s", resp.Status, bytes.TrimSpace(reason))
+	}
+	return nil
+}
+
+func (c *ClientConnection) hookEvent(event string, mount string, s *RtspSession, rawUrl string) *HookEvent {
+	e := &HookEvent{
+		Event:  event,
+		Mount:  mount,
+		Remote: c.Conn.RemoteAddr().String(),
+		User:   c.user,
+		Url:    rawUrl,
+		Time:   time.Now(),
+	}
+	if s != nil {
+		e.Session = s.ID
+	}
+	return e
+}
@@ -0,0 +1,112 @@
+// hooks_test
+package rtsp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func hookServer(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	s := httptest.NewServer(handler)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestPostHookAllows(t *testing.T) {
+	var got HookEvent
+	s := hookServer(t, func(w http.ResponseWriter, req *http.Request) {
+		if err := json.NewDecoder(req.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	e := &HookEvent{Event: HookPlay, Mount: "/vod", Remote: "127.0.0.1:1234"}
+	if err := postHook(s.URL, time.Second, e); err != nil {
+		t.Fatalf("2xx should allow: %v", err)
+	}
+	if got.Event != HookPlay || got.Mount != "/vod" || got.Remote != "127.0.0.1:1234" {
+		t.Errorf("hook got %+v", got)
+	}
+}
+
+func TestPostHookDenies(t *testing.T) {
+	s := hookServer(t, func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("not for you\n"))
+	})
+	err := postHook(s.URL, time.Second, &HookEvent{Event: HookDescribe})
+	if err == nil {
+		t.Fatal("403 should deny")
+	}
+	if !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), "not for you") {
+		t.Errorf("reason missing: %v", err)
+	}
+}
+
+func TestPostHookUnreachable(t *testing.T) {
+	s := httptest.NewServer(http.NotFoundHandler())
+	url := s.URL
+	s.Close()
+	if err := postHook(url, time.Second, &HookEvent{Event: HookConnect}); err == nil {
+		t.Fatal("an unreachable hook should deny")
+	}
+}
+
+func TestPostHookTimeout(t *testing.T) {
+	release := make(chan struct{})
+	s := hookServer(t, func(w http.ResponseWriter, req *http.Request) {
+		<-release
+	})
+	defer close(release)
+	start := time.Now()
+	if err := postHook(s.URL, 50*time.Millisecond, &HookEvent{Event: HookConnect}); err == nil {
+		t.Fatal("a hook that does not answer in time should deny")
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("timeout not applied, took %v", time.Since(start))
+	}
+}
+
+func TestRunHooks(t *testing.T) {
+	status := http.StatusOK
+	posts := 0
+	s := hookServer(t, func(w http.ResponseWriter, req *http.Request) {
+		posts++
+		w.WriteHeader(status)
+	})
+	r := NewRtspServer()
+	r.HookUrl = s.URL
+	e := &HookEvent{Event: HookPlay, Mount: "/vod"}
+	if err := r.runHooks(e); err != nil {
+		t.Fatalf("2xx should allow: %v", err)
+	}
+
+	status = http.StatusUnauthorized
+	if err := r.runHooks(e); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("401 should deny: %v", err)
+	}
+
+	/*a callback that denies is final, the url is not asked*/
+	status = http.StatusOK
+	posts = 0
+	r.AddHook(func(e *HookEvent) error {
+		return errors.New("callback says no")
+	})
+	if err := r.runHooks(e); err == nil || err.Error() != "callback says no" {
+		t.Fatalf("callback should deny: %v", err)
+	}
+	if posts != 0 {
+		t.Errorf("hook url asked after a denial")
+	}
+
+	r = NewRtspServer()
+	r.HookUrl = "http://127.0.0.1:1/"
+	if err := r.runHooks(e); err == nil {
+		t.Fatal("an unreachable hook url should deny")
+	}
+}
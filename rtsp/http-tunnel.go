@@ -0,0 +1,141 @@
+// http-tunnel
+package rtsp
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+/*
+rtsp over http as quicktime does it: a GET carries everything the server sends,
+a POST with the same x-sessioncookie carries the client's requests base64 encoded
+*/
+
+func headerValue(headers map[string]string, name string) (string, bool) {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func (r *RtspServer) addTunnel(cookie string, c *ClientConnection) bool {
+	r.tunnelLock.Lock()
+	defer r.tunnelLock.Unlock()
+	if _, ok := r.tunnels[cookie]; ok {
+		return false
+	}
+	r.tunnels[cookie] = c
+	return true
+}
+
+/*the GET half for a POST; one POST at a time, another one while it is attached would run requests alongside it*/
+func (r *RtspServer) attachTunnel(cookie string) (*ClientConnection, string) {
+	r.tunnelLock.Lock()
+	defer r.tunnelLock.Unlock()
+	g := r.tunnels[cookie]
+	if g == nil {
+		return nil, "404 Not Found"
+	}
+	if g.tunnelPosted {
+		return nil, "409 Conflict"
+	}
+	g.tunnelPosted = true
+	return g, ""
+}
+
+func (r *RtspServer) detachTunnel(g *ClientConnection) {
+	r.tunnelLock.Lock()
+	defer r.tunnelLock.Unlock()
+	g.tunnelPosted = false
+}
+
+func (r *RtspServer) removeTunnel(c *ClientConnection) {
+	r.tunnelLock.Lock()
+	defer r.tunnelLock.Unlock()
+	if c.tunnelCookie != "" && r.tunnels[c.tunnelCookie] == c {
+		delete(r.tunnels, c.tunnelCookie)
+	}
+}
+
+/*false once the connection is done with*/
+func (c *ClientConnection) openTunnel(req *RequestInfo, rd *bufio.Reader) bool {
+	cookie, ok := headerValue(req.Headers, "x-sessioncookie")
+	if !ok || cookie == "" {
+		c.writeResponse(httpResponse("400 Bad Request", ""))
+		return false
+	}
+	switch req.Method {
+	case "GET":
+		if !c.rtsp.addTunnel(cookie, c) {
+			c.writeResponse(httpResponse("400 Bad Request", ""))
+			return false
+		}
+		c.tunnelCookie = cookie
+		log.Printf("http tunnel %s opened by %v\n", cookie, c.Conn.RemoteAddr())
+		if err := c.writeResponse(httpResponse("200 OK", "application/x-rtsp-tunnelled")); err != nil {
+			log.Println(err)
+			return false
+		}
+		return true /*stays open, the client only ever reads from it*/
+	case "POST":
+		g, status := c.rtsp.attachTunnel(cookie)
+		if g == nil {
+			c.writeResponse(httpResponse(status, ""))
+			return false
+		}
+		defer c.rtsp.detachTunnel(g)
+		/*no response to the POST, its requests are answered on the GET connection; g.reqLock keeps them in line with the GET goroutine*/
+		g.serve(bufio.NewReader(&base64Reader{src: rd}))
+		return false
+	}
+	c.writeResponse(httpResponse("405 Method Not Allowed", ""))
+	return false
+}
+
+func httpResponse(status string, contentType string) string {
+	resp := fmt.Sprintf("HTTP/1.0 %s\r\nDate: %s\r\nServer: SimpleRtsp\r\nConnection: close\r\nCache-Control: no-store\r\nPragma: no-cache\r\n", status,
+		time.Now().Format("Mon, Jan 2 2006 15:04:05 GMT"))
+	if contentType != "" {
+		resp += fmt.Sprintf("Content-Type: %s\r\n", contentType)
+	}
+	return resp + "\r\n"
+}
+
+/*decodes every 4 characters on their own: clients pad each request, so the stream may have = in the middle*/
+type base64Reader struct {
+	src     io.ByteReader
+	quantum []byte
+	out     []byte
+}
+
+func (b *base64Reader) Read(p []byte) (int, error) {
+	for len(b.out) == 0 {
+		ch, err := b.src.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if ch == '\r' || ch == '\n' || ch == ' ' || ch == '\t' {
+			continue
+		}
+		b.quantum = append(b.quantum, ch)
+		if len(b.quantum) < 4 {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(string(b.quantum))
+		b.quantum = b.quantum[:0]
+		if err != nil {
+			return 0, err
+		}
+		b.out = data
+	}
+	n := copy(p, b.out)
+	b.out = b.out[n:]
+	return n, nil
+}
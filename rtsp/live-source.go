@@ -0,0 +1,125 @@
+// live-source
+package rtsp
+
+import (
+	"errors"
+	"strings"
+
+	"gortc.io/sdp"
+)
+
+type LiveTrack struct {
+	MediaType   string
+	PayloadType string
+	Control     string
+	RtpMap      string
+	Fmtp        string
+}
+
+/*media pushed by a publisher (ANNOUNCE/RECORD) or pulled from an upstream, relayed to every viewer through one hub*/
+type LiveSource struct {
+	Tracks    []*LiveTrack
+	publisher interface{}
+	hub       *StreamHub
+}
+
+func NewLiveSource(tracks []*LiveTrack, publisher interface{}, gopCacheSize int) *LiveSource {
+	l := &LiveSource{
+		Tracks:    tracks,
+		publisher: publisher,
+		hub:       NewStreamHub(),
+	}
+	if gopCacheSize > 0 {
+		for i, t := range tracks {
+			if codec := t.Codec(); codec != "" {
+				l.hub.EnableGopCache(i, codec, gopCacheSize)
+			}
+		}
+	}
+	return l
+}
+
+/*"96 H264/90000" -> H264, empty for anything that is not h264/h265 video*/
+func (t *LiveTrack) Codec() string {
+	rtpMap := strings.ToUpper(t.RtpMap)
+	switch {
+	case t.MediaType != "video":
+		return ""
+	case strings.Contains(rtpMap, "H264"):
+		return CodecH264
+	case strings.Contains(rtpMap, "H265"), strings.Contains(rtpMap, "HEVC"):
+		return CodecH265
+	}
+	return ""
+}
+
+func parseAnnounceSDP(body []byte) ([]*LiveTrack, error) {
+	var sdpSession sdp.Session
+	sdpSession, err := sdp.DecodeSession(body, sdpSession)
+	if err != nil {
+		return nil, err
+	}
+	d := sdp.NewDecoder(sdpSession)
+	sdpMsg := &sdp.Message{}
+	if err := d.Decode(sdpMsg); err != nil {
+		return nil, err
+	}
+	var tracks []*LiveTrack
+	for _, v := range sdpMsg.Medias {
+		t := &LiveTrack{
+			MediaType: v.Description.Type,
+			Control:   v.Attribute("control"),
+			RtpMap:    v.Attribute("rtpmap"),
+			Fmtp:      v.Attribute("fmtp"),
+		}
+		if len(v.Description.Formats) > 0 {
+			t.PayloadType = v.Description.Formats[0]
+		}
+		tracks = append(tracks, t)
+	}
+	if len(tracks) == 0 {
+		return nil, errors.New("sdp has no media")
+	}
+	return tracks, nil
+}
+
+/*SETUP url -> announced track, by control attribute or the order of SETUP requests*/
+func (l *LiveSource) findTrack(rawUrl string, setupCount int) int {
+	for i, t := range l.Tracks {
+		if t.Control != "" && (rawUrl == t.Control || strings.HasSuffix(rawUrl, "/"+t.Control)) {
+			return i
+		}
+	}
+	if setupCount < len(l.Tracks) {
+		return setupCount
+	}
+	return -1
+}
+
+func (l *LiveSource) WritePacket(track int, pkt []byte) {
+	l.hub.WritePacket(track, pkt)
+}
+
+func (l *LiveSource) WriteRTCP(track int, pkt []byte) {
+	l.hub.WriteRTCP(track, pkt)
+}
+
+func (l *LiveSource) AddViewer(t *RtpTransport) {
+	l.hub.Subscribe(t)
+}
+
+func (l *LiveSource) RemoveViewer(t *RtpTransport) {
+	l.hub.Unsubscribe(t)
+}
+
+/*publisher went away, viewer sessions are ended and interleaved players are disconnected*/
+func (l *LiveSource) Close() {
+	for _, v := range l.hub.Close() {
+		if v.session != nil {
+			v.session.manager.Remove(v.session)
+		}
+		if v.Protocol == TCP && v.conn != nil {
+			v.conn.Conn.Close()
+		}
+	}
+}
@@ -0,0 +1,305 @@
+// media-stream
+package rtsp
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type MountConfig struct {
+	Path           string   `mapstructure:"path" json:"path"`
+	File           string   `mapstructure:"file" json:"file,omitempty"`
+	Audio          string   `mapstructure:"audio" json:"audio,omitempty"`
+	Playlist       []string `mapstructure:"playlist" json:"playlist,omitempty"` /*video files played after file*/
+	Loop           bool     `mapstructure:"loop" json:"loop,omitempty"`
+	Publish        bool     `mapstructure:"publish" json:"publish,omitempty"`
+	Source         string   `mapstructure:"source" json:"source,omitempty"` /*upstream rtsp url, credentials included*/
+	IdleTimeout    int      `mapstructure:"idle_timeout" json:"idle_timeout,omitempty"`
+	MulticastGroup string   `mapstructure:"multicast_group" json:"multicast_group,omitempty"`
+	MulticastPort  int      `mapstructure:"multicast_port" json:"multicast_port,omitempty"`
+	MulticastTTL   int      `mapstructure:"multicast_ttl" json:"multicast_ttl,omitempty"`
+	GopCacheSize   int      `mapstructure:"gop_cache_size" json:"gop_cache_size,omitempty"`
+	FrameRate      float64  `mapstructure:"frame_rate" json:"frame_rate,omitempty"`
+}
+
+type MediaStream struct {
+	Path           string
+	FileName       string
+	AudioFileName  string
+	Playlist       []string
+	Loop           bool
+	Publish        bool
+	Source         string
+	MulticastGroup string
+	MulticastPort  int
+	MulticastTTL   int
+	GopCacheSize   int           /*bytes, 0 takes the server default, negative disables*/
+	IdleTimeout    time.Duration /*of the upstream of a relayed mount, 0 takes the server default*/
+	FrameRate      float64       /*of video files without timing info, 0 takes the server default*/
+	multicast      map[int]*MulticastGroup
+	live           *LiveSource
+	proxy          *RtspProxy
+	info           map[string]*StreamInfo
+	dynamic        bool
+	publishes      int /*publish sessions the mount has seen*/
+	lock           sync.Mutex
+}
+
+func NewMediaStream(cfg *MountConfig) *MediaStream {
+	return &MediaStream{
+		Path:           normalizeMountPath(cfg.Path),
+		FileName:       cfg.File,
+		AudioFileName:  cfg.Audio,
+		Playlist:       cfg.Playlist,
+		Loop:           cfg.Loop,
+		Publish:        cfg.Publish,
+		Source:         cfg.Source,
+		MulticastGroup: cfg.MulticastGroup,
+		MulticastPort:  cfg.MulticastPort,
+		MulticastTTL:   cfg.MulticastTTL,
+		GopCacheSize:   cfg.GopCacheSize,
+		IdleTimeout:    time.Second * time.Duration(cfg.IdleTimeout),
+		FrameRate:      cfg.FrameRate,
+		multicast:      make(map[int]*MulticastGroup),
+		info:           make(map[string]*StreamInfo),
+	}
+}
+
+func (s *MediaStream) Close() {
+	s.lock.Lock()
+	multicast, live, proxy := s.multicast, s.live, s.proxy
+	s.multicast, s.live, s.proxy = make(map[int]*MulticastGroup), nil, nil
+	s.lock.Unlock()
+	if proxy != nil {
+		proxy.Stop()
+	}
+	for _, g := range multicast {
+		g.Close()
+	}
+	if live != nil {
+		live.Close()
+	}
+}
+
+/*first file of each track of the mount in trackID order: video first, then audio*/
+func (s *MediaStream) FileTracks() []string {
+	var files []string
+	if video := s.videoFiles(); len(video) > 0 {
+		files = append(files, video[0])
+	}
+	if s.AudioFileName != "" {
+		files = append(files, s.AudioFileName)
+	}
+	return files
+}
+
+func (s *MediaStream) TrackCount() int {
+	if live := s.Live(); live != nil {
+		return len(live.Tracks)
+	}
+	return len(s.FileTracks())
+}
+
+func (s *MediaStream) videoFiles() []string {
+	var files []string
+	if s.FileName != "" {
+		files = append(files, s.FileName)
+	}
+	return append(files, s.Playlist...)
+}
+
+/*every file of a track: the video playlist, or the single audio file*/
+func (s *MediaStream) trackFiles(track int) []string {
+	if video := s.videoFiles(); len(video) > 0 && track == 0 {
+		return video
+	}
+	if files := s.FileTracks(); track >= 0 && track < len(files) {
+		return files[track : track+1]
+	}
+	return nil
+}
+
+/*parameter sets and length of a file, probed on first use*/
+func (s *MediaStream) probe(fileName string) (*StreamInfo, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.info[fileName] == nil {
+		info, err := probeFile(fileName, s.FrameRate)
+		if err != nil {
+			return nil, err
+		}
+		s.info[fileName] = info
+	}
+	return s.info[fileName], nil
+}
+
+/*parameter sets of a track's first file and the length of all of them, 0 when looping*/
+func (s *MediaStream) TrackInfo(track int) (*StreamInfo, error) {
+	files := s.trackFiles(track)
+	if len(files) == 0 {
+		return nil, errors.New("no such track")
+	}
+	first, err := s.probe(files[0])
+	if err != nil {
+		return nil, err
+	}
+	info := *first
+	for _, f := range files[1:] {
+		next, err := s.probe(f)
+		if err != nil {
+			return nil, err
+		}
+		info.Duration += next.Duration
+	}
+	if s.Loop {
+		info.Duration = 0
+	}
+	return &info, nil
+}
+
+/*file playback of one track*/
+func (s *MediaStream) NewPlayer(track int, t *RtpTransport) (*FilePlayer, error) {
+	files := s.trackFiles(track)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("mount %s has no track %d", s.Path, track)
+	}
+	list := &Playlist{Files: files, Loop: s.Loop}
+	for _, f := range files {
+		info, err := s.probe(f)
+		if err != nil {
+			return nil, err
+		}
+		list.Durations = append(list.Durations, info.Duration)
+	}
+	return NewPlaylistPlayer(list, t, s.FrameRate)
+}
+
+/*length of the longest file track, 0 when unknown, looping or live*/
+func (s *MediaStream) Duration() float64 {
+	duration := 0.0
+	for i := range s.FileTracks() {
+		if info, err := s.TrackInfo(i); err == nil && info.Duration > duration {
+			duration = info.Duration
+		}
+	}
+	return duration
+}
+
+func (s *MediaStream) Live() *LiveSource {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.live
+}
+
+/*published or relayed, as opposed to played from files*/
+func (s *MediaStream) IsLive() bool {
+	return s.Publish || s.Source != ""
+}
+
+/*live source of the mount, relayed mounts pull their upstream now if nobody did*/
+func (s *MediaStream) AcquireLive() *LiveSource {
+	if s.Source == "" {
+		return s.Live()
+	}
+	s.lock.Lock()
+	if s.proxy == nil {
+		s.proxy = NewRtspProxy(s.Source, s, s.IdleTimeout)
+	}
+	proxy := s.proxy
+	s.lock.Unlock()
+	return proxy.Acquire()
+}
+
+func (s *MediaStream) StartPublish(tracks []*LiveTrack, publisher *RtspSession) (*LiveSource, error) {
+	if !s.Publish {
+		return nil, errors.New("mount does not accept publishing")
+	}
+	live, err := s.startLive(tracks, publisher)
+	if err != nil {
+		return nil, err
+	}
+	s.lock.Lock()
+	s.publishes++
+	again := s.publishes > 1
+	s.lock.Unlock()
+	if again {
+		Metrics.Add("rtsp_reconnects_total", 1, "mount", s.Path, "kind", "publish")
+	}
+	return live, nil
+}
+
+/*publisher is the session or proxy feeding the mount; multicast groups still running from the last one move on to it*/
+func (s *MediaStream) startLive(tracks []*LiveTrack, publisher interface{}) (*LiveSource, error) {
+	s.lock.Lock()
+	if s.live != nil {
+		s.lock.Unlock()
+		return nil, errors.New("mount is already being published")
+	}
+	live := NewLiveSource(tracks, publisher, s.GopCacheSize)
+	s.live = live
+	groups := s.multicastGroups()
+	s.lock.Unlock()
+	for _, g := range groups {
+		g.attachLive(live)
+	}
+	return live, nil
+}
+
+/*s.lock held; Join takes s.lock under the group's lock, so the groups are only locked once s.lock is released*/
+func (s *MediaStream) multicastGroups() []*MulticastGroup {
+	groups := make([]*MulticastGroup, 0, len(s.multicast))
+	for _, g := range s.multicast {
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+/*returns true when the mount should go away with its publisher*/
+func (s *MediaStream) StopPublish(publisher interface{}) bool {
+	s.lock.Lock()
+	live := s.live
+	if live == nil || live.publisher != publisher {
+		s.lock.Unlock()
+		return false
+	}
+	s.live = nil
+	groups := s.multicastGroups()
+	s.lock.Unlock()
+	live.Close()
+	for _, g := range groups {
+		g.detachLive()
+	}
+	return s.dynamic
+}
+
+/*mount paths are kept as "/a/b": leading slash, no trailing slash*/
+func normalizeMountPath(p string) string {
+	p = "/" + strings.Trim(strings.TrimSpace(p), "/")
+	return p
+}
+
+/*rtsp://host/mount/trackID=1 -> 1, the mount url itself means the first track*/
+func urlTrackID(rawUrl string) int {
+	m := regexp.MustCompile("^trackID=(\\d+)$").FindStringSubmatch(path.Base(urlMountPath(rawUrl)))
+	if m == nil {
+		return 0
+	}
+	track, _ := strconv.Atoi(m[1])
+	return track
+}
+
+/*rtsp://host:port/a/b/trackID=0 -> /a/b/trackID=0*/
+func urlMountPath(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.Path == "" {
+		return normalizeMountPath(rawUrl)
+	}
+	return normalizeMountPath(u.Path)
+}
@@ -9,6 +9,7 @@ import (
 type MediaFileSource struct {
 	Offset   uint32
 	FileSize uint32
+	Codec    string
 	data     []byte
 }
 
@@ -20,6 +21,7 @@ func (m *MediaFileSource) ReadFileData(fileName string) error {
 	var e error
 	m.data, e = ioutil.ReadFile(fileName)
 	m.FileSize = uint32(len(m.data))
+	m.Codec = detectCodec(fileName, m.data)
 	return e
 }
 
@@ -30,15 +32,15 @@ func (m *MediaFileSource) GetNextNalu() []byte {
 	var findStartCode bool = false
 	var i uint32 = 0
 	for m.Offset < m.FileSize {
-		if !findStartCode && bytes.Compare(NAL4, m.data[m.Offset:m.Offset+4]) == 0 {
+		if !findStartCode && bytes.HasPrefix(m.data[m.Offset:], NAL4) {
 			m.Offset += 4
 			i = m.Offset
 			findStartCode = true
-		} else if !findStartCode && bytes.Compare(NAL3, m.data[m.Offset:m.Offset+3]) == 0 {
+		} else if !findStartCode && bytes.HasPrefix(m.data[m.Offset:], NAL3) {
 			m.Offset += 3
 			i = m.Offset
 			findStartCode = true
-		} else if findStartCode && (bytes.Compare(NAL4, m.data[m.Offset:m.Offset+4]) == 0 || bytes.Compare(NAL3, m.data[m.Offset:m.Offset+3]) == 0) {
+		} else if findStartCode && (bytes.HasPrefix(m.data[m.Offset:], NAL4) || bytes.HasPrefix(m.data[m.Offset:], NAL3)) {
 			sz := m.Offset - i
 			rbytes := make([]byte, sz)
 			copy(rbytes, m.data[i:m.Offset])
@@ -55,6 +57,13 @@ func (m *MediaFileSource) GetNextNalu() []byte {
 	return nil
 }
 
+func (m *MediaFileSource) PeekNextNalu() []byte {
+	offset := m.Offset
+	nalu := m.GetNextNalu()
+	m.Offset = offset
+	return nalu
+}
+
 func (m *MediaFileSource) GetOneFrame() ([]byte, bool) {
 	var iFrame bool = false
 	var s []byte
@@ -63,7 +72,7 @@ func (m *MediaFileSource) GetOneFrame() ([]byte, bool) {
 	var i int = -1
 
 	for m.Offset < m.FileSize {
-		if !findStartCode && bytes.Compare(NAL4, m.data[m.Offset:m.Offset+4]) == 0 {
+		if !findStartCode && bytes.HasPrefix(m.data[m.Offset:], NAL4) {
 			if i == -1 {
 				i = int(m.Offset)
 			}
@@ -76,7 +85,7 @@ func (m *MediaFileSource) GetOneFrame() ([]byte, bool) {
 				endRead = true
 			}
 			findStartCode = true
-		} else if !findStartCode && bytes.Compare(NAL3, m.data[m.Offset:m.Offset+3]) == 0 {
+		} else if !findStartCode && bytes.HasPrefix(m.data[m.Offset:], NAL3) {
 			if i == -1 {
 				i = int(m.Offset)
 			}
@@ -89,7 +98,7 @@ func (m *MediaFileSource) GetOneFrame() ([]byte, bool) {
 				endRead = true
 			}
 			findStartCode = true
-		} else if findStartCode && (bytes.Compare(NAL4, m.data[m.Offset:m.Offset+4]) == 0 || bytes.Compare(NAL3, m.data[m.Offset:m.Offset+3]) == 0) {
+		} else if findStartCode && (bytes.HasPrefix(m.data[m.Offset:], NAL4) || bytes.HasPrefix(m.data[m.Offset:], NAL3)) {
 			findStartCode = false
 			if endRead {
 				aul := []byte{0x00, 0x00, 0x00, 0x01, 0x09, 0x30}
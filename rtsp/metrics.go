@@ -0,0 +1,240 @@
+// metrics
+package rtsp
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+/*counters and gauges in the prometheus text format, written by hand to keep the package free of dependencies*/
+type MetricsRegistry struct {
+	families map[string]*metricFamily
+	lock     sync.Mutex
+}
+
+type metricFamily struct {
+	kind    string /*counter or gauge*/
+	help    string
+	samples map[string]float64 /*`{mount="/live"}` -> value*/
+}
+
+var Metrics = newDefaultMetrics()
+
+/*rtp sent by transport protocol, counted without a lock since it is per packet*/
+var sentPackets, sentBytes [3]uint64
+
+/*unpacketizers of the running clients, their counters are read at scrape time*/
+var clientStreams = struct {
+	streams map[*RTPunpacket]bool
+	lock    sync.Mutex
+}{streams: make(map[*RTPunpacket]bool)}
+
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{
+		families: make(map[string]*metricFamily),
+	}
+}
+
+func newDefaultMetrics() *MetricsRegistry {
+	m := NewMetricsRegistry()
+	m.Describe("rtsp_connections", "gauge", "Open RTSP control connections.")
+	m.Describe("rtsp_sessions", "gauge", "Active sessions per mount.")
+	m.Describe("rtsp_sent_packets_total", "counter", "RTP packets sent per transport.")
+	m.Describe("rtsp_sent_bytes_total", "counter", "RTP bytes sent per transport.")
+	m.Describe("rtsp_requests_total", "counter", "RTSP requests by method and response status.")
+	m.Describe("rtsp_auth_failures_total", "counter", "Requests whose credentials were rejected.")
+	m.Describe("rtsp_reconnects_total", "counter", "Upstream reconnects of relayed mounts and publishers coming back to a mount.")
+	m.Describe("rtsp_client_rtp_packets_received_total", "counter", "RTP packets received by the client.")
+	m.Describe("rtsp_client_rtp_packets_lost", "gauge", "RTP packets the client expected but did not receive.")
+	m.Describe("rtsp_client_rtp_jitter_seconds", "gauge", "Interarrival jitter seen by the client.")
+	return m
+}
+
+func (m *MetricsRegistry) Describe(name string, kind string, help string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if f, ok := m.families[name]; ok {
+		f.kind, f.help = kind, help
+		return
+	}
+	m.families[name] = &metricFamily{kind: kind, help: help, samples: make(map[string]float64)}
+}
+
+var labelEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+/*"mount", "/live" -> {mount="/live"}*/
+func labelString(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+/*m.lock held, families nobody described are untyped*/
+func (m *MetricsRegistry) family(name string) *metricFamily {
+	f, ok := m.families[name]
+	if !ok {
+		f = &metricFamily{kind: "untyped", samples: make(map[string]float64)}
+		m.families[name] = f
+	}
+	return f
+}
+
+/*labels are name, value pairs*/
+func (m *MetricsRegistry) Add(name string, delta float64, labels ...string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.family(name).samples[labelString(labels)] += delta
+}
+
+func (m *MetricsRegistry) Set(name string, value float64, labels ...string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.family(name).samples[labelString(labels)] = value
+}
+
+/*drop every sample of a gauge, for label sets that may go away (a removed mount)*/
+func (m *MetricsRegistry) Reset(name string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.family(name).samples = make(map[string]float64)
+}
+
+func (m *MetricsRegistry) WriteTo(w io.Writer) (int64, error) {
+	m.lock.Lock()
+	names := make([]string, 0, len(m.families))
+	for name, f := range m.families {
+		if len(f.samples) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		f := m.families[name]
+		if f.help != "" {
+			fmt.Fprintf(&b, "# HELP %s %s\n", name, f.help)
+		}
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, f.kind)
+		labels := make([]string, 0, len(f.samples))
+		for l := range f.samples {
+			labels = append(labels, l)
+		}
+		sort.Strings(labels)
+		for _, l := range labels {
+			fmt.Fprintf(&b, "%s%s %g\n", name, l, f.samples[l])
+		}
+	}
+	m.lock.Unlock()
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (m *MetricsRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteTo(w)
+}
+
+func (p ProtocolName) String() string {
+	switch p {
+	case TCP:
+		return "tcp"
+	case UDP:
+		return "udp"
+	case Multicast:
+		return "multicast"
+	}
+	return "unknown"
+}
+
+func countSent(p ProtocolName, size int) {
+	if p >= 0 && int(p) < len(sentPackets) {
+		atomic.AddUint64(&sentPackets[p], 1)
+		atomic.AddUint64(&sentBytes[p], uint64(size))
+	}
+}
+
+func trackClientStream(r *RTPunpacket) {
+	if r.Stream == "" {
+		return
+	}
+	clientStreams.lock.Lock()
+	clientStreams.streams[r] = true
+	clientStreams.lock.Unlock()
+}
+
+/*the last values stay on the scrape after the client is gone*/
+func untrackClientStream(r *RTPunpacket) {
+	clientStreams.lock.Lock()
+	delete(clientStreams.streams, r)
+	clientStreams.lock.Unlock()
+	setClientMetrics(r)
+}
+
+func setClientMetrics(r *RTPunpacket) {
+	if r.Stream == "" {
+		return
+	}
+	received, lost, jitter := r.Stats()
+	Metrics.Set("rtsp_client_rtp_packets_received_total", float64(received), "stream", r.Stream)
+	Metrics.Set("rtsp_client_rtp_packets_lost", float64(lost), "stream", r.Stream)
+	Metrics.Set("rtsp_client_rtp_jitter_seconds", jitter, "stream", r.Stream)
+}
+
+/*unknown methods are counted together so clients cannot grow the label set*/
+func countRequest(method string, resp string) {
+	if !strings.Contains(", "+allowedCommandNames+", ", ", "+method+", ") {
+		method = "OTHER"
+	}
+	status := "none"
+	if fields := strings.Fields(resp); len(fields) > 1 {
+		status = fields[1]
+	}
+	Metrics.Add("rtsp_requests_total", 1, "method", method, "status", status)
+}
+
+/*gauges taken from the server's state at scrape time*/
+func (r *RtspServer) updateMetrics() {
+	r.connLock.Lock()
+	Metrics.Set("rtsp_connections", float64(len(r.conns)))
+	r.connLock.Unlock()
+
+	counts := make(map[string]int)
+	for _, s := range r.Streams() {
+		counts[s.Path] = 0
+	}
+	for _, s := range r.sessions.Sessions() {
+		if mount := s.Status().Mount; mount != "" {
+			counts[mount]++
+		}
+	}
+	Metrics.Reset("rtsp_sessions")
+	for mount, n := range counts {
+		Metrics.Set("rtsp_sessions", float64(n), "mount", mount)
+	}
+
+	for p := range sentPackets {
+		Metrics.Set("rtsp_sent_packets_total", float64(atomic.LoadUint64(&sentPackets[p])), "transport", ProtocolName(p).String())
+		Metrics.Set("rtsp_sent_bytes_total", float64(atomic.LoadUint64(&sentBytes[p])), "transport", ProtocolName(p).String())
+	}
+
+	clientStreams.lock.Lock()
+	for s := range clientStreams.streams {
+		setClientMetrics(s)
+	}
+	clientStreams.lock.Unlock()
+}
+
+func (r *RtspServer) handleMetrics(w http.ResponseWriter, req *http.Request) {
+	r.updateMetrics()
+	Metrics.ServeHTTP(w, req)
+}
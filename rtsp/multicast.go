@@ -0,0 +1,196 @@
+// multicast
+package rtsp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+
+	"golang.org/x/net/ipv4"
+)
+
+/*one shared packet flow per mount track, every multicast viewer of the track watches the same group*/
+type MulticastGroup struct {
+	Address   net.IP
+	Port      int
+	TTL       int
+	stream    *MediaStream
+	track     int
+	transport *RtpTransport
+	viewers   int
+	player    *FilePlayer
+	lock      sync.Mutex
+}
+
+func NewMulticastGroup(r *RtspServer, s *MediaStream, track int) (*MulticastGroup, error) {
+	var addr net.IP
+	if s.MulticastGroup != "" {
+		if addr = net.ParseIP(s.MulticastGroup).To4(); addr == nil || !addr.IsMulticast() {
+			return nil, fmt.Errorf("invalid multicast group %s", s.MulticastGroup)
+		}
+	} else {
+		addr = r.allocMulticastAddress()
+	}
+	ttl := s.MulticastTTL
+	if ttl <= 0 {
+		ttl = r.MulticastTTL
+	}
+
+	rtpConn, rtcpConn, err := r.allocUDPPortPair()
+	if err != nil {
+		return nil, err
+	}
+	port := s.MulticastPort&^1 + track*2
+	if s.MulticastPort <= 0 {
+		port = rtpConn.LocalAddr().(*net.UDPAddr).Port
+	}
+	for _, c := range []*net.UDPConn{rtpConn, rtcpConn} {
+		if err := ipv4.NewPacketConn(c).SetMulticastTTL(ttl); err != nil {
+			log.Println(err)
+		}
+	}
+	g := &MulticastGroup{
+		Address: addr,
+		Port:    port,
+		TTL:     ttl,
+		stream:  s,
+		track:   track,
+		transport: &RtpTransport{
+			Protocol:    Multicast,
+			Track:       track,
+			RtpChannel:  -1,
+			RtcpChannel: -1,
+			TTL:         ttl,
+			rtpConn:     rtpConn,
+			rtcpConn:    rtcpConn,
+			rtpAddr:     &net.UDPAddr{IP: addr, Port: port},
+			rtcpAddr:    &net.UDPAddr{IP: addr, Port: port + 1},
+		},
+	}
+	log.Printf("mount %s track %d multicast %s:%d-%d ttl %d\n", s.Path, track, addr, port, port+1, ttl)
+	return g, nil
+}
+
+/*per viewer transport, it only references the group and never owns the sockets*/
+func (g *MulticastGroup) NewTransport() *RtpTransport {
+	return &RtpTransport{
+		Protocol:       Multicast,
+		Track:          g.track,
+		RtpChannel:     -1,
+		RtcpChannel:    -1,
+		ClientRtpPort:  g.Port,
+		ClientRtcpPort: g.Port + 1,
+		TTL:            g.TTL,
+		rtpAddr:        g.transport.rtpAddr,
+		rtcpAddr:       g.transport.rtcpAddr,
+		group:          g,
+	}
+}
+
+/*the first viewer starts the flow, a file that already played to its end starts over*/
+func (g *MulticastGroup) Join() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.viewers++
+	if g.player != nil && g.player.Finished() {
+		g.player.Stop()
+		g.player = nil
+	} else if g.viewers > 1 {
+		return
+	}
+	if live := g.stream.Live(); live != nil {
+		g.transport.JoinLive(live)
+	} else if player, err := g.stream.NewPlayer(g.track, g.transport); err != nil {
+		log.Println(err)
+	} else {
+		g.player = player
+		g.player.Play()
+	}
+}
+
+/*the mount has a new publisher or upstream, a group with viewers follows it*/
+func (g *MulticastGroup) attachLive(l *LiveSource) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.viewers == 0 || g.player != nil {
+		return
+	}
+	g.transport.LeaveLive()
+	g.transport.JoinLive(l)
+}
+
+/*the live source is gone; viewers stay in the group and wait for the next one*/
+func (g *MulticastGroup) detachLive() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.transport.LeaveLive()
+}
+
+/*the last viewer stops it*/
+func (g *MulticastGroup) Leave() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.viewers == 0 {
+		return
+	}
+	g.viewers--
+	if g.viewers == 0 {
+		g.stop()
+	}
+}
+
+func (g *MulticastGroup) Close() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.viewers > 0 {
+		g.stop()
+		g.viewers = 0
+	}
+	g.transport.Close()
+}
+
+func (g *MulticastGroup) stop() {
+	if g.player != nil {
+		g.player.Stop()
+		g.player = nil
+	}
+	g.transport.LeaveLive()
+}
+
+/*multicast_base + n, skipping .0 and .255*/
+func (r *RtspServer) allocMulticastAddress() net.IP {
+	r.portLock.Lock()
+	defer r.portLock.Unlock()
+	base := net.ParseIP(r.MulticastBase).To4()
+	if base == nil {
+		base = net.IPv4(239, 255, 42, 0).To4()
+	}
+	for {
+		r.nextMulticast++
+		n := binary.BigEndian.Uint32(base) + r.nextMulticast
+		if n&0xff != 0 && n&0xff != 0xff {
+			ip := make(net.IP, 4)
+			binary.BigEndian.PutUint32(ip, n)
+			return ip
+		}
+	}
+}
+
+func (s *MediaStream) getMulticastGroup(r *RtspServer, track int) (*MulticastGroup, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.multicast[track] == nil {
+		if track >= len(s.FileTracks()) && !s.IsLive() {
+			return nil, errors.New("mount has no source for multicast")
+		}
+		g, err := NewMulticastGroup(r, s, track)
+		if err != nil {
+			return nil, err
+		}
+		s.multicast[track] = g
+	}
+	return s.multicast[track], nil
+}
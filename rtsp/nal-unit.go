@@ -0,0 +1,98 @@
+// nal-unit
+package rtsp
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+const (
+	CodecH264 = "H264"
+	CodecH265 = "H265"
+)
+
+/*codec of an elementary stream, by file extension or by the first parameter set found*/
+func detectCodec(fileName string, data []byte) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".h265", ".265", ".hevc":
+		return CodecH265
+	case ".h264", ".264", ".avc":
+		return CodecH264
+	}
+	mf := &MediaFileSource{FileSize: uint32(len(data)), data: data}
+	for nalu := mf.GetNextNalu(); nalu != nil; nalu = mf.GetNextNalu() {
+		if len(nalu) >= 2 && (nalu[0]>>1)&0x3f == 32 && nalu[1] == 0x01 { /*hevc vps*/
+			return CodecH265
+		}
+		if len(nalu) >= 1 && nalu[0]&0x1f == 7 { /*avc sps*/
+			return CodecH264
+		}
+	}
+	return CodecH264
+}
+
+func naluType(codec string, nalu []byte) byte {
+	if codec == CodecH265 {
+		return (nalu[0] >> 1) & 0x3f
+	}
+	return nalu[0] & 0x1f
+}
+
+/*coded slice, as opposed to parameter sets, sei, aud...*/
+func isVCL(codec string, nalu []byte) bool {
+	if len(nalu) == 0 {
+		return false
+	}
+	if codec == CodecH265 {
+		return naluType(codec, nalu) < 32
+	}
+	t := naluType(codec, nalu)
+	return t >= 1 && t <= 5
+}
+
+/*first_mb_in_slice == 0 (ue(v) '1') or first_slice_segment_in_pic_flag*/
+func isFirstSlice(codec string, nalu []byte) bool {
+	if codec == CodecH265 {
+		return len(nalu) > 2 && nalu[2]&0x80 != 0
+	}
+	return len(nalu) > 1 && nalu[1]&0x80 != 0
+}
+
+/*start of a decoder refresh point inside an rtp payload: parameter sets or the first fragment of an idr/irap*/
+func isKeyFramePayload(codec string, payload []byte) bool {
+	if codec == CodecH265 {
+		if len(payload) < 3 {
+			return false
+		}
+		switch t := (payload[0] >> 1) & 0x3f; {
+		case t >= 16 && t <= 21, t >= 32 && t <= 34:
+			return true
+		case t == 48: /*AP, first aggregated unit*/
+			return len(payload) > 4 && isKeyFramePayload(codec, payload[4:])
+		case t == 49: /*FU*/
+			ft := payload[2] & 0x3f
+			return payload[2]&0x80 != 0 && ft >= 16 && ft <= 21
+		}
+		return false
+	}
+	if len(payload) < 2 {
+		return false
+	}
+	switch payload[0] & 0x1f {
+	case 5, 7, 8:
+		return true
+	case 24: /*STAP-A, first aggregated unit*/
+		return len(payload) > 3 && isKeyFramePayload(codec, payload[3:])
+	case 28: /*FU-A*/
+		return payload[1]&0x80 != 0 && payload[1]&0x1f == 5
+	}
+	return false
+}
+
+/*the last slice of a picture carries the rtp marker and ends the frame*/
+func isFrameEnd(codec string, nalu []byte, next []byte) bool {
+	if !isVCL(codec, nalu) {
+		return false
+	}
+	return next == nil || !isVCL(codec, next) || isFirstSlice(codec, next)
+}
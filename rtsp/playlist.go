@@ -0,0 +1,136 @@
+// playlist
+package rtsp
+
+import (
+	"log"
+)
+
+/*files of one track played back to back, from the first again when Loop is set*/
+type Playlist struct {
+	Files     []string
+	Durations []float64 /*seconds of each file, needed to seek across files and around a loop*/
+	Loop      bool
+}
+
+/*reads the files of a playlist as one stream: timestamps keep counting over file boundaries*/
+type playlistReader struct {
+	list      *Playlist
+	frameRate float64
+	current   int
+	reader    frameReader
+	start     uint32 /*timestamp the current file started at*/
+	position  uint32 /*timestamp of the next frame*/
+}
+
+func newPlaylistReader(list *Playlist, frameRate float64) (*playlistReader, error) {
+	l := &playlistReader{
+		list:      list,
+		frameRate: frameRate,
+		current:   0,
+	}
+	if err := l.open(0); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+/*a file that is open already is rewound instead of read again*/
+func (l *playlistReader) open(i int) error {
+	if l.reader != nil && i == l.current {
+		l.reader.Seek(0)
+		return nil
+	}
+	reader, err := openFrameReader(l.list.Files[i], l.frameRate)
+	if err != nil {
+		return err
+	}
+	l.current, l.reader = i, reader
+	return nil
+}
+
+func (l *playlistReader) ClockRate() uint32 {
+	return l.reader.ClockRate()
+}
+
+func (l *playlistReader) PayloadType() byte {
+	return l.reader.PayloadType()
+}
+
+func (l *playlistReader) isVideo() bool {
+	_, ok := l.reader.(*videoReader)
+	return ok
+}
+
+func (l *playlistReader) NextFrame(rtp *RtpPacket, timestamp uint32) ([]string, uint32) {
+	for i := 0; i <= len(l.list.Files); i++ {
+		if pkts, duration := l.reader.NextFrame(rtp, timestamp); pkts != nil {
+			l.position += duration
+			return pkts, duration
+		}
+		next := l.current + 1
+		if next == len(l.list.Files) {
+			if !l.list.Loop {
+				return nil, 0
+			}
+			next = 0
+		}
+		if err := l.open(next); err != nil {
+			log.Println(err)
+			return nil, 0
+		}
+		l.start = l.position
+	}
+	return nil, 0
+}
+
+/*timestamp counts from the start of the first file, a looping list wraps around and keeps counting*/
+func (l *playlistReader) Seek(timestamp uint32) uint32 {
+	var durations []uint32
+	total := uint32(0)
+	for _, d := range l.list.Durations {
+		durations = append(durations, uint32(d*float64(l.ClockRate())))
+		total += durations[len(durations)-1]
+	}
+	if len(durations) != len(l.list.Files) || total == 0 {
+		/*lengths unknown: within the first file only*/
+		if err := l.open(0); err != nil {
+			log.Println(err)
+		}
+		l.start = 0
+		l.position = l.reader.Seek(timestamp)
+		return l.position
+	}
+	base := uint32(0)
+	if l.list.Loop {
+		base = timestamp / total * total
+		timestamp -= base
+	}
+	offset := uint32(0)
+	for i, d := range durations {
+		if timestamp < offset+d || i == len(durations)-1 {
+			if err := l.open(i); err != nil {
+				log.Println(err)
+				break
+			}
+			l.start = base + offset
+			l.position = l.start + l.reader.Seek(timestamp-offset)
+			return l.position
+		}
+		offset += d
+	}
+	return l.position
+}
+
+/*random access point before bound, within the current file of a video playlist*/
+func (l *playlistReader) SeekBefore(bound uint32) (uint32, bool) {
+	v, ok := l.reader.(*videoReader)
+	if !ok || bound <= l.start {
+		return 0, false
+	}
+	timestamp, ok := v.SeekBefore(bound - l.start)
+	if !ok {
+		return 0, false
+	}
+	l.position = l.start + timestamp
+	return l.position, true
+}
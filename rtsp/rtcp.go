@@ -5,8 +5,10 @@ import (
 	"bytes"
 	"encoding/binary"
 	//"fmt"
+	"log"
 	"math/rand"
 	"os"
+	"time"
 )
 
 type RTCPPacket struct {
@@ -33,6 +35,22 @@ func NewRTCP(id uint32) *RTCPPacket {
 	}
 }
 
+func (r *RTCPPacket) GenerateSR(rtpTime uint32, packetCount uint32, octetCount uint32) []byte {
+	buf := bytes.NewBuffer(nil)
+	buf.WriteByte(0x80)                               //version(10)padding(0)rc(00000)
+	buf.WriteByte(byte(RTCP_PT_SR))                   //packettype(200)
+	binary.Write(buf, binary.BigEndian, uint16(6))    //length
+	binary.Write(buf, binary.BigEndian, r.SenderSSRC) //sender ssrc
+
+	ntpSec, ntpFrac := ntpTime(time.Now())
+	binary.Write(buf, binary.BigEndian, ntpSec)      /*ntp timestamp msw*/
+	binary.Write(buf, binary.BigEndian, ntpFrac)     /*ntp timestamp lsw*/
+	binary.Write(buf, binary.BigEndian, rtpTime)     /*rtp timestamp*/
+	binary.Write(buf, binary.BigEndian, packetCount) /*sender's packet count*/
+	binary.Write(buf, binary.BigEndian, octetCount)  /*sender's octet count*/
+	return buf.Bytes()
+}
+
 func (r *RTCPPacket) GenerateRR(id uint32, lastTime uint32) []byte {
 	buf := bytes.NewBuffer(nil)
 	buf.WriteByte(0x81)                               //version(10)padding(0)rc(00001)
@@ -78,3 +96,38 @@ func (r *RTCPPacket) GenerateSD() []byte {
 	buf.Write(itemData)
 	return buf.Bytes()
 }
+
+func (r *RTCPPacket) GenerateBYE() []byte {
+	buf := bytes.NewBuffer(nil)
+	buf.WriteByte(0x81)                               //version(10)padding(0)sc(00001)
+	buf.WriteByte(byte(RTCP_PT_BYE))                  //packettype(203)
+	binary.Write(buf, binary.BigEndian, uint16(1))    //length
+	binary.Write(buf, binary.BigEndian, r.SenderSSRC) //ssrc leaving
+	return buf.Bytes()
+}
+
+/*seconds since 1900 and 1/2^32 fraction*/
+func ntpTime(t time.Time) (uint32, uint32) {
+	sec := uint32(t.Unix() + 2208988800)
+	frac := uint32((uint64(t.Nanosecond()) << 32) / 1e9)
+	return sec, frac
+}
+
+func logRtcpPacket(data []byte) {
+	if len(data) < 2 {
+		return
+	}
+	//rc := data[0] & 0x1f
+	switch data[1] {
+	case 200: /*sender report*/
+		log.Printf("rtcp packet type sender report.dataSize:%d\n", len(data))
+	case 201: /*receiver report*/
+		log.Printf("rtcp packet type receiver report.dataSize:%d\n", len(data))
+	case 202: /*source description item*/
+		log.Printf("rtcp packet type source description item.dataSize:%d\n", len(data))
+	case 203: /*byte*/
+		log.Printf("rtcp packet type byte.dataSize:%d\n", len(data))
+	case 204: /*app*/
+		log.Printf("rtcp packet type app.dataSize:%d\n", len(data))
+	}
+}
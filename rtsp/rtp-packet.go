@@ -41,11 +41,26 @@ func (r *RtpPacket) buildRtpHead(mark bool, timestamp uint32) {
 	binary.BigEndian.PutUint32(r.rtpHead[8:], r.ssrc)
 }
 
+/*one packet, timestamp is already in the clock rate of the track*/
+func (r *RtpPacket) BuildRTP(mark bool, payload []byte, timestamp uint32) string {
+	r.buildRtpHead(mark, timestamp)
+	temp := make([]byte, 12+len(payload))
+	copy(temp, r.rtpHead[:])
+	copy(temp[12:], payload)
+	return string(temp)
+}
+
+/*pts in milliseconds*/
 func (r *RtpPacket) BuildRTPWithAVCNALU(mark bool, payload []byte, pts uint32) []string {
+	return r.BuildRTPWithAVCNALUTimestamp(mark, payload, pts*90)
+}
+
+/*timestamp in 90kHz units as it goes on the wire, no millisecond rounding*/
+func (r *RtpPacket) BuildRTPWithAVCNALUTimestamp(mark bool, payload []byte, timestamp uint32) []string {
 	var ss []string
 
 	if len(payload) <= MTU {
-		r.buildRtpHead(mark, pts*90)
+		r.buildRtpHead(mark, timestamp)
 		temp := make([]byte, 12+len(payload))
 		copy(temp, r.rtpHead[:])
 		copy(temp[12:], payload)
@@ -62,11 +77,11 @@ func (r *RtpPacket) BuildRTPWithAVCNALU(mark bool, payload []byte, pts uint32) [
 		for k < i {
 			var s string
 			if k == 0 {
-				s = r.buildOneRTPWithFUA(tp, payload[1:(MTU-2)+1], false, pts, true, false)
+				s = r.buildOneRTPWithFUA(tp, payload[1:(MTU-2)+1], false, timestamp, true, false)
 			} else if k+1 == i {
-				s = r.buildOneRTPWithFUA(tp, payload[1+k*(MTU-2):], mark, pts, false, true)
+				s = r.buildOneRTPWithFUA(tp, payload[1+k*(MTU-2):], mark, timestamp, false, true)
 			} else {
-				s = r.buildOneRTPWithFUA(tp, payload[1+k*(MTU-2):1+(k+1)*(MTU-2)], false, pts, false, false)
+				s = r.buildOneRTPWithFUA(tp, payload[1+k*(MTU-2):1+(k+1)*(MTU-2)], false, timestamp, false, false)
 			}
 			k++
 			if len(s) != 0 {
@@ -77,11 +92,11 @@ func (r *RtpPacket) BuildRTPWithAVCNALU(mark bool, payload []byte, pts uint32) [
 	return ss
 }
 
-func (r *RtpPacket) buildOneRTPWithFUA(t byte, data []byte, mark bool, pts uint32, payload_start bool, payload_end bool) string {
+func (r *RtpPacket) buildOneRTPWithFUA(t byte, data []byte, mark bool, timestamp uint32, payload_start bool, payload_end bool) string {
 	if len(data) == 0 {
 		return ""
 	}
-	r.buildRtpHead(mark, pts*90)
+	r.buildRtpHead(mark, timestamp)
 	//	--	FU indicator	--
 	//	--	+---------------+	--
 	//	--	|0|1|2|3|4|5|6|7|	--
@@ -110,11 +125,17 @@ func (r *RtpPacket) buildOneRTPWithFUA(t byte, data []byte, mark bool, pts uint3
 	return r.buf.String()
 }
 
-func (r *RtpPacket) BuildRTPWithHEVCNALU(payload []byte, pts uint32) []string {
+/*pts in milliseconds*/
+func (r *RtpPacket) BuildRTPWithHEVCNALU(mark bool, payload []byte, pts uint32) []string {
+	return r.BuildRTPWithHEVCNALUTimestamp(mark, payload, pts*90)
+}
+
+/*timestamp in 90kHz units as it goes on the wire, no millisecond rounding*/
+func (r *RtpPacket) BuildRTPWithHEVCNALUTimestamp(mark bool, payload []byte, timestamp uint32) []string {
 	var ss []string
 
 	if len(payload) <= MTU {
-		r.buildRtpHead(true, pts*90)
+		r.buildRtpHead(mark, timestamp)
 		temp := make([]byte, 12+len(payload))
 		copy(temp, r.rtpHead[:])
 		copy(temp[12:], payload)
@@ -126,7 +147,7 @@ func (r *RtpPacket) BuildRTPWithHEVCNALU(payload []byte, pts uint32) []string {
 		head[1] = payload[1]
 
 		i := (len(payload) - 2) / (MTU - 3)
-		j := (len(payload) - 2) / (MTU - 3)
+		j := (len(payload) - 2) % (MTU - 3)
 		if j != 0 {
 			i += 1
 		}
@@ -135,11 +156,11 @@ func (r *RtpPacket) BuildRTPWithHEVCNALU(payload []byte, pts uint32) []string {
 			var s string
 
 			if k == 0 {
-				s = r.buildOneHEVCRTPWithFUA(head, payload[2:(MTU-3)+2], false, pts, true, false)
+				s = r.buildOneHEVCRTPWithFUA(head, payload[2:(MTU-3)+2], false, timestamp, true, false)
 			} else if k+1 == i {
-				s = r.buildOneHEVCRTPWithFUA(head, payload[2+k*(MTU-3):], true, pts, false, true)
+				s = r.buildOneHEVCRTPWithFUA(head, payload[2+k*(MTU-3):], mark, timestamp, false, true)
 			} else {
-				s = r.buildOneHEVCRTPWithFUA(head, payload[2+k*(MTU-3):2+(k+1)*(MTU-3)], false, pts, false, false)
+				s = r.buildOneHEVCRTPWithFUA(head, payload[2+k*(MTU-3):2+(k+1)*(MTU-3)], false, timestamp, false, false)
 			}
 			k++
 			if len(s) != 0 {
@@ -150,11 +171,11 @@ func (r *RtpPacket) BuildRTPWithHEVCNALU(payload []byte, pts uint32) []string {
 	return ss
 }
 
-func (r *RtpPacket) buildOneHEVCRTPWithFUA(naluHead [2]byte, data []byte, mark bool, pts uint32, payload_start bool, payload_end bool) string {
+func (r *RtpPacket) buildOneHEVCRTPWithFUA(naluHead [2]byte, data []byte, mark bool, timestamp uint32, payload_start bool, payload_end bool) string {
 	if len(data) == 0 {
 		return ""
 	}
-	r.buildRtpHead(mark, pts*90)
+	r.buildRtpHead(mark, timestamp)
 
 	var payloadHeader1, payloadHeader2, fuHeader byte
 	payloadHeader1 = (naluHead[0] & 0x81) | (49 << 1)
@@ -0,0 +1,101 @@
+// rtp-packet_test
+package rtsp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func hevcNALU(naluType byte, size int) []byte {
+	nalu := make([]byte, size)
+	nalu[0] = naluType<<1 | 0x01 /*layer id 0 with its low bit set, kept in the payload header*/
+	nalu[1] = 0x03               /*tid 3*/
+	for i := 2; i < size; i++ {
+		nalu[i] = byte(i)
+	}
+	return nalu
+}
+
+func TestBuildRTPWithHEVCNALU(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		mark    bool
+		packets int
+	}{
+		{"small single", 100, true, 1},
+		{"mtu single", MTU, true, 1},
+		{"one over mtu", MTU + 1, true, 2},
+		{"exactly two fragments", 2 + 2*(MTU-3), true, 2},
+		{"three fragments", 2 + 2*(MTU-3) + 1, true, 3},
+		{"big frame", 20000, true, 14},
+		{"no marker", 5000, false, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nalu := hevcNALU(19, tt.size)
+			p := NewRtpPacket(65534, 0x11223344, 96)
+			pkts := p.BuildRTPWithHEVCNALUTimestamp(tt.mark, nalu, 3003)
+			if len(pkts) != tt.packets {
+				t.Fatalf("%d packets, want %d", len(pkts), tt.packets)
+			}
+			var joined []byte
+			for i, s := range pkts {
+				pkt := []byte(s)
+				if len(pkt) > 12+MTU {
+					t.Errorf("packet %d is %d bytes", i, len(pkt))
+				}
+				if seq := binary.BigEndian.Uint16(pkt[2:]); seq != uint16(65534+i) {
+					t.Errorf("packet %d seq %d", i, seq)
+				}
+				if ts := binary.BigEndian.Uint32(pkt[4:]); ts != 3003 {
+					t.Errorf("packet %d timestamp %d", i, ts)
+				}
+				if ssrc := binary.BigEndian.Uint32(pkt[8:]); ssrc != 0x11223344 {
+					t.Errorf("packet %d ssrc %x", i, ssrc)
+				}
+				last := i == len(pkts)-1
+				if mark := pkt[1]&0x80 != 0; mark != (tt.mark && last) {
+					t.Errorf("packet %d marker %v", i, mark)
+				}
+				if pkt[1]&0x7f != 96 {
+					t.Errorf("packet %d payload type %d", i, pkt[1]&0x7f)
+				}
+				if len(pkts) == 1 {
+					if !bytes.Equal(pkt[12:], nalu) {
+						t.Errorf("single nal unit packet does not carry the nal unit")
+					}
+					continue
+				}
+				/*rfc 7798 4.4.3: payload header of type 49, then S|E|FuType*/
+				if pkt[12]>>1&0x3f != 49 || pkt[12]&0x81 != nalu[0]&0x81 || pkt[13] != nalu[1] {
+					t.Errorf("packet %d payload header % x", i, pkt[12:14])
+				}
+				fu := pkt[14]
+				if start := fu&0x80 != 0; start != (i == 0) {
+					t.Errorf("packet %d start bit %v", i, start)
+				}
+				if end := fu&0x40 != 0; end != last {
+					t.Errorf("packet %d end bit %v", i, end)
+				}
+				if fu&0x3f != 19 {
+					t.Errorf("packet %d fu type %d", i, fu&0x3f)
+				}
+				joined = append(joined, pkt[15:]...)
+			}
+			if len(pkts) > 1 && !bytes.Equal(joined, nalu[2:]) {
+				t.Errorf("fragments do not add up to the nal unit: %d bytes, want %d", len(joined), len(nalu)-2)
+			}
+		})
+	}
+}
+
+func TestBuildRTPWithHEVCNALUMilliseconds(t *testing.T) {
+	p := NewRtpPacket(0, 1, 96)
+	for _, pkt := range p.BuildRTPWithHEVCNALU(true, hevcNALU(1, 3000), 40) {
+		if ts := binary.BigEndian.Uint32([]byte(pkt[4:])); ts != 3600 {
+			t.Errorf("timestamp %d, want 3600", ts)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+// rtp-transport
+package rtsp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync/atomic"
+)
+
+type RtpTransport struct {
+	Protocol       ProtocolName
+	Track          int
+	RtpChannel     int
+	RtcpChannel    int
+	ClientRtpPort  int
+	ClientRtcpPort int
+	ServerRtpPort  int
+	ServerRtcpPort int
+	TTL            int
+	PacketsSent    uint32
+	OctetsSent     uint32
+	ssrc           uint32 /*of the last packet sent*/
+	rtpConn        *net.UDPConn
+	rtcpConn       *net.UDPConn
+	rtpAddr        *net.UDPAddr
+	rtcpAddr       *net.UDPAddr
+	conn           *ClientConnection
+	session        *RtspSession
+	group          *MulticastGroup
+	joined         bool
+	live           *LiveSource
+	hubState       *hubSubscriber /*the last subscription to live, a PLAY after PAUSE carries on from it*/
+	onRTP          func([]byte)
+	onRTCP         func([]byte)
+}
+
+func NewTCPTransport(c *ClientConnection, rtpChannel int, rtcpChannel int) *RtpTransport {
+	return &RtpTransport{
+		Protocol:    TCP,
+		RtpChannel:  rtpChannel,
+		RtcpChannel: rtcpChannel,
+		conn:        c,
+	}
+}
+
+/*bind a server port pair and point it at the client's rtp/rtcp ports*/
+func NewUDPTransport(c *ClientConnection, clientIP net.IP, clientRtpPort int, clientRtcpPort int) (*RtpTransport, error) {
+	rtpConn, rtcpConn, err := c.rtsp.allocUDPPortPair()
+	if err != nil {
+		return nil, err
+	}
+	t := &RtpTransport{
+		Protocol:       UDP,
+		RtpChannel:     -1,
+		RtcpChannel:    -1,
+		ClientRtpPort:  clientRtpPort,
+		ClientRtcpPort: clientRtcpPort,
+		ServerRtpPort:  rtpConn.LocalAddr().(*net.UDPAddr).Port,
+		ServerRtcpPort: rtcpConn.LocalAddr().(*net.UDPAddr).Port,
+		rtpConn:        rtpConn,
+		rtcpConn:       rtcpConn,
+		rtpAddr:        &net.UDPAddr{IP: clientIP, Port: clientRtpPort},
+		rtcpAddr:       &net.UDPAddr{IP: clientIP, Port: clientRtcpPort},
+		conn:           c,
+	}
+	go t.readUDP(rtpConn, false)
+	go t.readUDP(rtcpConn, true)
+	return t, nil
+}
+
+func (t *RtpTransport) WriteRTP(pkt string) error {
+	var err error
+	switch t.Protocol {
+	case TCP:
+		err = t.conn.writeInterleaved(t.RtpChannel, pkt)
+	case UDP, Multicast:
+		_, err = t.rtpConn.WriteToUDP([]byte(pkt), t.rtpAddr)
+	default:
+		err = errors.New("unknown transport")
+	}
+	if err == nil {
+		if len(pkt) >= 12 {
+			atomic.StoreUint32(&t.ssrc, binary.BigEndian.Uint32([]byte(pkt[8:12])))
+		}
+		countSent(t.Protocol, len(pkt))
+		atomic.AddUint32(&t.PacketsSent, 1)
+		atomic.AddUint32(&t.OctetsSent, uint32(len(pkt)-12))
+	}
+	return err
+}
+
+func (t *RtpTransport) WriteRTCP(pkt []byte) error {
+	switch t.Protocol {
+	case TCP:
+		return t.conn.writeInterleaved(t.RtcpChannel, string(pkt))
+	case UDP, Multicast:
+		_, err := t.rtcpConn.WriteToUDP(pkt, t.rtcpAddr)
+		return err
+	}
+	return errors.New("unknown transport")
+}
+
+/*tell the client the stream is gone, nothing to say if nothing was sent*/
+func (t *RtpTransport) SendBye() error {
+	if atomic.LoadUint32(&t.PacketsSent) == 0 {
+		return nil
+	}
+	rtcp := NewRTCP(0)
+	rtcp.SenderSSRC = atomic.LoadUint32(&t.ssrc)
+	return t.WriteRTCP(rtcp.GenerateBYE())
+}
+
+/*Transport header value for the SETUP response*/
+func (t *RtpTransport) String() string {
+	switch t.Protocol {
+	case TCP:
+		return fmt.Sprintf("RTP/AVP/TCP;unicast;interleaved=%d-%d", t.RtpChannel, t.RtcpChannel)
+	case UDP:
+		return fmt.Sprintf("RTP/AVP;unicast;destination=%s;source=%s;client_port=%d-%d;server_port=%d-%d",
+			t.rtpAddr.IP, localIP(t.conn.Conn), t.ClientRtpPort, t.ClientRtcpPort, t.ServerRtpPort, t.ServerRtcpPort)
+	case Multicast:
+		return fmt.Sprintf("RTP/AVP;multicast;destination=%s;port=%d-%d;ttl=%d", t.rtpAddr.IP, t.rtpAddr.Port, t.rtcpAddr.Port, t.TTL)
+	}
+	return ""
+}
+
+/*multicast viewers share the group flow instead of getting their own*/
+func (t *RtpTransport) JoinGroup() {
+	if t.group != nil && !t.joined {
+		t.joined = true
+		t.group.Join()
+	}
+}
+
+/*receive a publisher's packets instead of reading a file*/
+func (t *RtpTransport) JoinLive(l *LiveSource) {
+	t.live = l
+	l.AddViewer(t)
+}
+
+func (t *RtpTransport) LeaveLive() {
+	if t.live != nil {
+		t.live.RemoveViewer(t)
+		t.live = nil
+	}
+}
+
+func (t *RtpTransport) LeaveGroup() {
+	if t.group != nil && t.joined {
+		t.joined = false
+		t.group.Leave()
+	}
+}
+
+func (t *RtpTransport) Close() {
+	t.LeaveLive()
+	t.LeaveGroup()
+	if t.rtpConn != nil {
+		t.rtpConn.Close()
+	}
+	if t.rtcpConn != nil {
+		t.rtcpConn.Close()
+	}
+}
+
+/*drain what the client sends to our ports, rtcp receiver reports are logged*/
+func (t *RtpTransport) readUDP(conn *net.UDPConn, isRtcp bool) {
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if t.session != nil {
+			t.session.touch()
+		}
+		if isRtcp {
+			logRtcpPacket(buf[:n])
+			if t.onRTCP != nil {
+				t.onRTCP(buf[:n])
+			}
+		} else if t.onRTP != nil {
+			t.onRTP(buf[:n])
+		}
+	}
+}
+
+func localIP(conn net.Conn) net.IP {
+	if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		return addr.IP
+	}
+	return net.IPv4zero
+}
+
+func remoteIP(conn net.Conn) net.IP {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP
+	}
+	return net.IPv4zero
+}
+
+/*find a free even/odd port pair in [RtpPortMin, RtpPortMax]*/
+func (r *RtspServer) allocUDPPortPair() (*net.UDPConn, *net.UDPConn, error) {
+	r.portLock.Lock()
+	defer r.portLock.Unlock()
+	min := int(r.RtpPortMin) &^ 1
+	max := int(r.RtpPortMax)
+	if max <= min {
+		return nil, nil, errors.New("invalid rtp port range")
+	}
+	count := (max - min + 1) / 2
+	for i := 0; i < count; i++ {
+		if r.nextRtpPort < min || r.nextRtpPort+1 > max {
+			r.nextRtpPort = min
+		}
+		port := r.nextRtpPort
+		r.nextRtpPort += 2
+
+		rtpConn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+		if err != nil {
+			continue
+		}
+		rtcpConn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port + 1})
+		if err != nil {
+			rtpConn.Close()
+			continue
+		}
+		rtpConn.SetWriteBuffer(1024 * 512)
+		return rtpConn, rtcpConn, nil
+	}
+	log.Printf("no free udp port in %d-%d\n", min, max)
+	return nil, nil, errors.New("no free udp port")
+}
@@ -5,6 +5,9 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
 )
 
 type HEVCNALUnitType int
@@ -38,6 +41,17 @@ type RTPunpacket struct {
 	NALUType       uint8
 	RawCallback    FrameCallback
 	Arg            interface{}
+	Stream         string /*label of the loss and jitter metrics*/
+	ClockRate      uint32
+	received       uint64 /*received, lost and jitterBits are read by the metrics scrape, atomically*/
+	lost           int64  /*expected minus received, duplicates make it go down*/
+	jitterBits     uint64
+	jitter         float64 /*rfc 3550 interarrival jitter, timestamp units*/
+	seqStarted     bool
+	baseSeq        uint32
+	maxSeq         uint32 /*extended with the wrap count*/
+	lastTransit    float64
+	startTime      time.Time
 }
 
 func NewRTPUnpacket() *RTPunpacket {
@@ -49,6 +63,8 @@ func NewRTPUnpacket() *RTPunpacket {
 		frameBuffer:    bytes.NewBuffer(nil),
 		RawCallback:    nil,
 		Arg:            nil,
+		Stream:         "",
+		ClockRate:      90000,
 	}
 }
 
@@ -58,6 +74,8 @@ func (r *RTPunpacket) InputRTPData(data []byte, mediaType string) {
 		return
 	}
 
+	r.updateStats(binary.BigEndian.Uint16(data[2:]), binary.BigEndian.Uint32(data[4:]))
+
 	ex := (data[0] & 0x10) >> 4
 	if ex != 0 {
 		fmt.Println("has extension field")
@@ -153,6 +171,44 @@ func (r *RTPunpacket) parseAVCRTP(data []byte) {
 	}
 }
 
+/*rfc 3550 appendix a.1 and a.8, without the probation of a new source*/
+func (r *RTPunpacket) updateStats(seq uint16, timestamp uint32) {
+	now := time.Now()
+	if !r.seqStarted {
+		r.seqStarted = true
+		r.baseSeq, r.maxSeq = uint32(seq), uint32(seq)
+		r.startTime = now
+	}
+	cycles := r.maxSeq &^ 0xffff
+	delta := int16(seq - uint16(r.maxSeq))
+	if delta > 0 {
+		if seq < uint16(r.maxSeq) {
+			cycles += 1 << 16
+		}
+		r.maxSeq = cycles | uint32(seq)
+	}
+	received := atomic.AddUint64(&r.received, 1)
+	atomic.StoreInt64(&r.lost, int64(r.maxSeq-r.baseSeq+1)-int64(received))
+
+	arrival := now.Sub(r.startTime).Seconds() * float64(r.ClockRate)
+	transit := arrival - float64(timestamp)
+	if received > 1 {
+		d := math.Abs(transit - r.lastTransit)
+		r.jitter += (d - r.jitter) / 16
+		atomic.StoreUint64(&r.jitterBits, math.Float64bits(r.jitter))
+	}
+	r.lastTransit = transit
+}
+
+/*safe from any goroutine; jitter is in seconds*/
+func (r *RTPunpacket) Stats() (received uint64, lost int64, jitter float64) {
+	jitter = math.Float64frombits(atomic.LoadUint64(&r.jitterBits))
+	if r.ClockRate > 0 {
+		jitter /= float64(r.ClockRate)
+	}
+	return atomic.LoadUint64(&r.received), atomic.LoadInt64(&r.lost), jitter
+}
+
 func (r *RTPunpacket) SetCallback(cb FrameCallback, arg interface{}) {
 	r.RawCallback = cb
 	r.Arg = arg
@@ -4,6 +4,7 @@ package rtsp
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -12,6 +13,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"gortc.io/sdp"
@@ -22,6 +24,7 @@ type ProtocolName int
 const (
 	TCP ProtocolName = iota
 	UDP
+	Multicast
 )
 
 type RtspClient struct {
@@ -47,13 +50,20 @@ type RtspClient struct {
 	VideoControlPath string
 	AudioControlPath string
 	rtp              *RTPunpacket
-	RTPDataCallback  func([]byte, interface{})
+	RTPDataCallback  func(int, []byte, interface{}) /*every interleaved packet with its channel*/
 	RTPDataUser      interface{}
+	PlayingCallback  func(interface{})
+	SDP              []byte
 	HasVideo         bool
 	SendVideoSteup   bool
 	HasAudio         bool
 	SendAudioSetup   bool
 	HasQuit          chan int
+	playing          bool
+	reqLock          sync.Mutex
+	cancel           chan struct{} /*closed by Cancel, stops a dial in progress*/
+	cancelOnce       sync.Once
+	connLock         sync.Mutex /*Conn is set by OpenStream and closed by Cancel from another goroutine*/
 }
 
 func NewRtspClient(rawUrl string, id string) *RtspClient {
@@ -74,9 +84,12 @@ func NewRtspClient(rawUrl string, id string) *RtspClient {
 	if err != nil {
 		port = 554
 	}
+	u.User = nil /*credentials go in the Authorization header only*/
+	rtp := NewRTPUnpacket()
+	rtp.Stream = u.String()
 	return &RtspClient{
 		CSeq:            1,
-		BaseUrl:         rawUrl,
+		BaseUrl:         u.String(),
 		auth:            auth,
 		Host:            u.Hostname(),
 		Port:            uint16(port),
@@ -91,25 +104,47 @@ func NewRtspClient(rawUrl string, id string) *RtspClient {
 		StartTime:       0.0,
 		EndTime:         -1.0,
 		CurrentCmd:      "DESCRIBE",
-		rtp:             NewRTPUnpacket(),
+		rtp:             rtp,
 		RTPDataCallback: nil,
 		HasVideo:        false,
 		SendVideoSteup:  false,
 		HasAudio:        false,
 		SendAudioSetup:  false,
-		HasQuit:         make(chan int),
+		HasQuit:         make(chan int, 1),
+		playing:         false,
+		cancel:          make(chan struct{}),
 	}
 }
 
 func (cli *RtspClient) OpenStream() int {
 	defer cli.SetQuit()
-	addr := fmt.Sprintf("%s:%d", cli.Host, cli.Port)
-	conn, err := net.DialTimeout("tcp", addr, time.Second*3)
+	trackClientStream(cli.rtp)
+	defer untrackClientStream(cli.rtp)
+	addr := net.JoinHostPort(cli.Host, strconv.Itoa(int(cli.Port)))
+	ctx, stop := context.WithTimeout(context.Background(), time.Second*3)
+	defer stop()
+	go func() {
+		select {
+		case <-cli.cancel:
+			stop()
+		case <-ctx.Done():
+		}
+	}()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
 	if err != nil {
 		log.Println(err)
 		return 1
 	}
+	cli.connLock.Lock()
+	select {
+	case <-cli.cancel: /*cancelled right as the dial finished*/
+		cli.connLock.Unlock()
+		conn.Close()
+		return 1
+	default:
+	}
 	cli.Conn = conn
+	cli.connLock.Unlock()
 	cli.ConnRW = bufio.NewReadWriter(bufio.NewReaderSize(conn, 204800), bufio.NewWriterSize(conn, 204800))
 	cli.sendRequest(cli.CurrentCmd, cli.BaseUrl, 0, 1)
 	buf1 := make([]byte, 1)
@@ -136,6 +171,9 @@ func (cli *RtspClient) OpenStream() int {
 				return 1
 			}
 
+			if cli.RTPDataCallback != nil {
+				cli.RTPDataCallback(int(buf1[0]), data, cli.RTPDataUser)
+			}
 			if int(buf1[0]) == cli.vRTCPChannel {
 
 			} else if int(buf1[0]) == cli.vRTPChannel {
@@ -183,7 +221,11 @@ func (cli *RtspClient) OpenStream() int {
 									log.Println(err)
 									return 1
 								}
+								if cli.CurrentCmd != "DESCRIBE" { /*keepalive answers may carry parameters*/
+									break
+								}
 								log.Println(string(content))
+								cli.SDP = content
 								/*parse sdp*/
 								var sdpSession sdp.Session
 								sdpSession, err := sdp.DecodeSession(content, sdpSession)
@@ -263,9 +305,15 @@ func (cli *RtspClient) OpenStream() int {
 									cli.sendRequest(cli.CurrentCmd, cli.BaseUrl, 0, 1)
 								}
 
+							} else if cli.CurrentCmd == "PLAY" && !cli.playing {
+								cli.playing = true
+								if cli.PlayingCallback != nil {
+									cli.PlayingCallback(cli.RTPDataUser)
+								}
 							}
 						} else {
-							/*TODO*/
+							log.Printf("%s failed: %d %s\n", cli.CurrentCmd, resp.ResponseCode, resp.ResponseString)
+							return 1
 						}
 						break
 					}
@@ -281,6 +329,8 @@ func (cli *RtspClient) OpenStream() int {
 }
 
 func (cli *RtspClient) sendRequest(cmd string, url string, a int, b int) {
+	cli.reqLock.Lock()
+	defer cli.reqLock.Unlock()
 	cli.CSeq++
 	extraHeaders := bytes.NewBuffer(nil)
 	authenticatorStr := cli.auth.CreateAuthenticatorString(cmd, cli.BaseUrl)
@@ -339,24 +389,50 @@ func (cli *RtspClient) sendRequest(cmd string, url string, a int, b int) {
 		}
 	}
 	extraHeaders.WriteString("\r\n")
-	log.Printf(extraHeaders.String())
+	log.Printf(redactAuthorization(extraHeaders.String()))
 	cli.ConnRW.Write(extraHeaders.Bytes())
 	cli.ConnRW.Flush()
 }
 
+/*stop at once, wherever OpenStream is: the dial is abandoned and the connection closed, which ends the read loop*/
+func (cli *RtspClient) Cancel() {
+	cli.cancelOnce.Do(func() {
+		close(cli.cancel)
+	})
+	cli.connLock.Lock()
+	defer cli.connLock.Unlock()
+	if cli.Conn != nil {
+		cli.Conn.Close()
+	}
+}
+
 func (cli *RtspClient) StopStream() {
+	cli.connLock.Lock()
+	conn := cli.Conn
+	cli.connLock.Unlock()
+	if conn == nil {
+		return
+	}
 	cli.CurrentCmd = "TEARDOWN"
 	cli.sendRequest(cli.CurrentCmd, cli.BaseUrl, 0, 1)
-	_ = <-cli.HasQuit
+	select {
+	case <-cli.HasQuit:
+	case <-time.After(time.Second * 3): /*no answer to the TEARDOWN*/
+	}
+
+	conn.Close()
+}
 
-	cli.Conn.Close()
+/*servers that ignore rtcp receiver reports expire the session without it*/
+func (cli *RtspClient) KeepAlive() {
+	cli.sendRequest("GET_PARAMETER", cli.BaseUrl, 0, 1)
 }
 
 func (cli *RtspClient) SetRawDataCallback(cb FrameCallback, arg interface{}) {
 	cli.rtp.SetCallback(cb, arg)
 }
 
-func (cli *RtspClient) SetRTPDataCallback(cb func([]byte, interface{}), arg interface{}) {
+func (cli *RtspClient) SetRTPDataCallback(cb func(int, []byte, interface{}), arg interface{}) {
 	cli.RTPDataCallback = cb
 	cli.RTPDataUser = arg
 }
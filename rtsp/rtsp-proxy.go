@@ -0,0 +1,269 @@
+// rtsp-proxy
+package rtsp
+
+import (
+	"errors"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+)
+
+/*pulls an upstream rtsp url on demand and relays it to the mount's viewers, the upstream credentials never leave the server*/
+type RtspProxy struct {
+	Url           string
+	RetryInterval time.Duration
+	KeepAlive     time.Duration
+	IdleTimeout   time.Duration /*upstream is closed once nobody watched for this long*/
+	WaitTimeout   time.Duration /*how long a viewer waits for the upstream to come up*/
+	stream        *MediaStream
+	live          *LiveSource
+	channels      map[int]int /*interleaved channel of the upstream -> track of the live source*/
+	lastUsed      time.Time
+	ready         chan struct{} /*closed and replaced whenever the upstream starts playing*/
+	quit          chan struct{}
+	done          chan struct{}
+	stopped       bool /*by Stop, for good: the mount is gone*/
+	lock          sync.RWMutex
+}
+
+func NewRtspProxy(rawUrl string, stream *MediaStream, idleTimeout time.Duration) *RtspProxy {
+	return &RtspProxy{
+		Url:           rawUrl,
+		RetryInterval: time.Second * 5,
+		KeepAlive:     time.Second * 20,
+		IdleTimeout:   idleTimeout,
+		WaitTimeout:   time.Second * 10,
+		stream:        stream,
+		channels:      make(map[int]int),
+		ready:         make(chan struct{}),
+		stopped:       false,
+	}
+}
+
+/*the upstream url without its user info, for logs*/
+func redactUrl(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.User == nil {
+		return rawUrl
+	}
+	u.User = nil
+	return u.String()
+}
+
+/*starts pulling if needed and waits for the upstream; viewers arriving together share one upstream connection*/
+func (p *RtspProxy) Acquire() *LiveSource {
+	deadline := time.After(p.WaitTimeout)
+	for {
+		p.lock.Lock()
+		if p.stopped {
+			p.lock.Unlock()
+			return nil
+		}
+		p.lastUsed = time.Now()
+		p.start()
+		live, ready := p.live, p.ready
+		p.lock.Unlock()
+		if live != nil {
+			return live
+		}
+		select {
+		case <-ready:
+		case <-deadline:
+			return nil
+		}
+	}
+}
+
+/*p.lock held*/
+func (p *RtspProxy) start() {
+	if p.quit != nil {
+		return
+	}
+	prev := p.done
+	p.quit = make(chan struct{})
+	p.done = make(chan struct{})
+	go p.run(prev, p.quit, p.done)
+}
+
+/*also wakes the viewers waiting in Acquire*/
+func (p *RtspProxy) Stop() {
+	p.lock.Lock()
+	quit, done := p.quit, p.done
+	p.quit = nil
+	if !p.stopped {
+		p.stopped = true
+		close(p.ready)
+	}
+	p.lock.Unlock()
+	if quit != nil {
+		close(quit)
+	}
+	if done != nil {
+		<-done
+	}
+}
+
+/*connect, relay until the upstream goes away or nobody watches, retry*/
+func (p *RtspProxy) run(prev chan struct{}, quit chan struct{}, done chan struct{}) {
+	defer close(done)
+	if prev != nil { /*the last upstream connection may still be closing*/
+		<-prev
+	}
+	for {
+		cli := NewRtspClient(p.Url, "")
+		if cli == nil {
+			log.Println("invalid upstream url", redactUrl(p.Url))
+			return
+		}
+		cli.SetRTPDataCallback(p.onPacket, nil)
+		cli.PlayingCallback = func(interface{}) {
+			if err := p.startLive(cli); err != nil {
+				log.Println(err)
+				cli.Conn.Close()
+			}
+		}
+		closed := make(chan struct{})
+		go func() {
+			cli.OpenStream()
+			close(closed)
+		}()
+
+		ticker := time.NewTicker(time.Second)
+		lastKeepAlive := time.Now()
+	relay:
+		for {
+			select {
+			case <-closed:
+				break relay
+			case <-ticker.C:
+				if p.idle(quit) {
+					ticker.Stop()
+					p.cancel(cli, closed)
+					p.stopLive(cli)
+					log.Printf("no viewer on %s, upstream closed\n", p.stream.Path)
+					return
+				}
+				if time.Since(lastKeepAlive) > p.KeepAlive && p.Live() != nil {
+					lastKeepAlive = time.Now()
+					cli.KeepAlive()
+				}
+			case <-quit:
+				ticker.Stop()
+				p.cancel(cli, closed)
+				p.stopLive(cli)
+				return
+			}
+		}
+		ticker.Stop()
+		p.stopLive(cli)
+		log.Printf("upstream of %s lost, retry in %v\n", p.stream.Path, p.RetryInterval)
+
+		select {
+		case <-quit:
+			return
+		case <-time.After(p.RetryInterval):
+		}
+		if p.idle(quit) {
+			return
+		}
+		Metrics.Add("rtsp_reconnects_total", 1, "mount", p.stream.Path, "kind", "proxy")
+	}
+}
+
+/*the upstream may still be dialing or in its handshake, so it is cut off rather than torn down, and not waited for forever*/
+func (p *RtspProxy) cancel(cli *RtspClient, closed chan struct{}) {
+	cli.Cancel()
+	select {
+	case <-closed:
+	case <-time.After(time.Second * 3):
+		log.Printf("upstream of %s did not close in time\n", p.stream.Path)
+	}
+}
+
+/*true when nobody watched for IdleTimeout, the proxy then counts as stopped and the next Acquire starts it again*/
+func (p *RtspProxy) idle(quit chan struct{}) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.quit != quit {
+		return false
+	}
+	if p.live != nil && p.live.hub.Count() > 0 {
+		p.lastUsed = time.Now()
+		return false
+	}
+	if time.Since(p.lastUsed) < p.IdleTimeout {
+		return false
+	}
+	p.quit, p.live = nil, nil
+	return true
+}
+
+func (p *RtspProxy) Live() *LiveSource {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.live
+}
+
+/*the client sets up the first video and the first audio media on channels 0-1 and 2-3*/
+func (p *RtspProxy) startLive(cli *RtspClient) error {
+	medias, err := parseAnnounceSDP(cli.SDP)
+	if err != nil {
+		return err
+	}
+	var tracks []*LiveTrack
+	channels := make(map[int]int)
+	for _, mediaType := range []string{"video", "audio"} {
+		for _, t := range medias {
+			if t.MediaType == mediaType {
+				base := 0
+				if mediaType == "audio" {
+					base = 2
+				}
+				channels[base], channels[base+1] = len(tracks), len(tracks)
+				tracks = append(tracks, t)
+				break
+			}
+		}
+	}
+	if len(tracks) == 0 {
+		return errors.New("upstream has no audio or video")
+	}
+	live, err := p.stream.startLive(tracks, cli)
+	if err != nil {
+		return err
+	}
+	p.lock.Lock()
+	p.live, p.channels = live, channels
+	if !p.stopped {
+		close(p.ready)
+		p.ready = make(chan struct{})
+	}
+	p.lock.Unlock()
+	log.Printf("relay %s -> %s\n", redactUrl(p.Url), p.stream.Path)
+	return nil
+}
+
+func (p *RtspProxy) stopLive(cli *RtspClient) {
+	p.lock.Lock()
+	if p.live != nil && p.live.publisher == cli {
+		p.live = nil
+	}
+	p.lock.Unlock()
+	p.stream.StopPublish(cli)
+}
+
+func (p *RtspProxy) onPacket(channel int, data []byte, arg interface{}) {
+	p.lock.RLock()
+	live := p.live
+	track, ok := p.channels[channel]
+	p.lock.RUnlock()
+	if live == nil || !ok {
+		return
+	}
+	if channel%2 == 1 {
+		live.WriteRTCP(track, data)
+	} else {
+		live.WritePacket(track, data)
+	}
+}
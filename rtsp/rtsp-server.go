@@ -2,74 +2,393 @@
 package rtsp
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
-
-	"github.com/spf13/viper"
+	"net/http"
+	"path"
+	"sync"
+	"time"
 )
 
 type RtspServer struct {
-	Host     string
-	Port     uint16
-	listener *net.TCPListener
-	bQuit    bool
+	ConfigFile    string /*config.json in the working directory when empty*/
+	Host          string
+	Port          uint16
+	HttpPort      uint16 /*rtsp over http tunnel, 0 to disable*/
+	TLSPort       uint16 /*rtsps, served when TLSCert and TLSKey are set*/
+	TLSCert       string
+	TLSKey        string
+	AdminAddr     string /*json admin api, empty to disable; keep it on a private address*/
+	AdminToken    string /*bearer token the admin api asks for when set*/
+	HookUrl       string /*gets every HookEvent as a json POST*/
+	HookTimeout   time.Duration
+	RtpPortMin    uint16
+	RtpPortMax    uint16
+	MulticastBase string
+	MulticastTTL  int
+	GopCacheSize  int
+	IdleTimeout   time.Duration /*of relayed upstreams without viewers*/
+	FrameRate     float64       /*of video files that do not signal one*/
+	Transports    []string      /*tcp, udp, multicast; empty allows all*/
+	DynamicMounts bool          /*ANNOUNCE to an unknown path makes a mount for the publisher; off, only publish mounts take one*/
+	MaxConns      int           /*0 for no limit*/
+	MaxSessions   int
+	mounts        map[string]MountConfig /*mounts of the last config, a reload diffs against them*/
+	reloadLock    sync.Mutex
+	listeners     []net.Listener
+	admin         *http.Server
+	hooks         []HookFunc
+	hookLock      sync.Mutex
+	closing       bool
+	conns         map[*ClientConnection]bool
+	connLock      sync.Mutex
+	wg            sync.WaitGroup /*connections and the extra accept loops*/
+	streams       map[string]*MediaStream
+	streamLock    sync.RWMutex
+	nextRtpPort   int
+	nextMulticast uint32
+	portLock      sync.Mutex
+	sessions      *SessionManager
+	auth          *ServerAuth
+	tunnels       map[string]*ClientConnection /*x-sessioncookie -> GET connection*/
+	tunnelLock    sync.Mutex
 	/**/
 }
 
 func NewRtspServer() *RtspServer {
 	return &RtspServer{
-		Host:     "",
-		Port:     8554,
-		listener: nil,
-		bQuit:    false,
+		ConfigFile:    "",
+		Host:          "",
+		Port:          8554,
+		HttpPort:      0,
+		TLSPort:       322,
+		TLSCert:       "",
+		TLSKey:        "",
+		AdminAddr:     "",
+		AdminToken:    "",
+		HookUrl:       "",
+		HookTimeout:   time.Second * 3,
+		RtpPortMin:    30000,
+		RtpPortMax:    30999,
+		MulticastBase: "239.255.42.0",
+		MulticastTTL:  16,
+		GopCacheSize:  4 << 20,
+		IdleTimeout:   time.Second * 10,
+		FrameRate:     25,
+		Transports:    nil,
+		DynamicMounts: false,
+		MaxConns:      0,
+		MaxSessions:   0,
+		mounts:        make(map[string]MountConfig),
+		listeners:     nil,
+		admin:         nil,
+		hooks:         nil,
+		closing:       false,
+		conns:         make(map[*ClientConnection]bool),
+		streams:       make(map[string]*MediaStream),
+		sessions:      NewSessionManager(time.Second * 60),
+		auth:          NewServerAuth("SimpleRtsp"),
+		tunnels:       make(map[string]*ClientConnection),
 	}
 }
 
-func (r *RtspServer) GetConfig() error {
-	v := viper.New()
-	v.SetConfigName("config")
-	v.SetConfigType("json")
-	v.AddConfigPath(".")
+func (r *RtspServer) dynamicMounts() bool {
+	r.streamLock.RLock()
+	defer r.streamLock.RUnlock()
+	return r.DynamicMounts
+}
 
-	if err := v.ReadInConfig(); err != nil {
-		log.Println(err)
-		return err
+func (r *RtspServer) AddStream(s *MediaStream) error {
+	r.streamLock.Lock()
+	defer r.streamLock.Unlock()
+	if _, ok := r.streams[s.Path]; ok {
+		return fmt.Errorf("mount %s already exists", s.Path)
+	}
+	if s.GopCacheSize == 0 {
+		s.GopCacheSize = r.GopCacheSize
+	}
+	if s.IdleTimeout == 0 {
+		s.IdleTimeout = r.IdleTimeout
+	}
+	if s.FrameRate <= 0 {
+		s.FrameRate = r.FrameRate
+	}
+	r.streams[s.Path] = s
+	if s.Source != "" {
+		log.Printf("add mount %s -> %s\n", s.Path, redactUrl(s.Source))
+	} else {
+		log.Printf("add mount %s -> %s\n", s.Path, s.FileName)
 	}
-	r.Host = v.GetString("host")
-	r.Port = uint16(v.GetUint32("port"))
 	return nil
 }
 
-func (r *RtspServer) Start() bool {
-	addrStr := fmt.Sprintf(":%d", r.Port)
-	addr, err := net.ResolveTCPAddr("tcp", addrStr)
+/*closing waits for a relayed upstream to stop, so it happens outside streamLock*/
+func (r *RtspServer) RemoveStream(mountPath string) *MediaStream {
+	mountPath = normalizeMountPath(mountPath)
+	r.streamLock.Lock()
+	s, ok := r.streams[mountPath]
+	if ok {
+		delete(r.streams, mountPath)
+	}
+	r.streamLock.Unlock()
+	if ok {
+		s.Close()
+	}
+	return s
+}
+
+func (r *RtspServer) GetStream(mountPath string) *MediaStream {
+	r.streamLock.RLock()
+	defer r.streamLock.RUnlock()
+	return r.streams[normalizeMountPath(mountPath)]
+}
+
+/*find the mount a request url points at, the last path element may be a track control (trackID=0)*/
+func (r *RtspServer) FindStream(rawUrl string) *MediaStream {
+	p := urlMountPath(rawUrl)
+	if s := r.GetStream(p); s != nil {
+		return s
+	}
+	if dir := path.Dir(p); dir != "/" && dir != p {
+		return r.GetStream(dir)
+	}
+	return nil
+}
+
+func (r *RtspServer) Streams() []*MediaStream {
+	r.streamLock.RLock()
+	defer r.streamLock.RUnlock()
+	ss := make([]*MediaStream, 0, len(r.streams))
+	for _, s := range r.streams {
+		ss = append(ss, s)
+	}
+	return ss
+}
+
+func listenTCP(port uint16) (net.Listener, error) {
+	addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
-		log.Println(err)
-		return false
+		return nil, err
+	}
+	l, err := net.ListenTCP("tcp", addr)
+	if err != nil {
+		return nil, err
 	}
-	if r.listener, err = net.ListenTCP("tcp", addr); err != nil {
+	log.Println("start listen on", addr)
+	return l, nil
+}
+
+/*blocks until Shutdown (or Stop)*/
+func (r *RtspServer) Start() bool {
+	var listeners []net.Listener
+	fail := func(err error) bool {
 		log.Println(err)
+		for _, l := range listeners {
+			l.Close()
+		}
 		return false
 	}
-	log.Println("start listen on", addr)
-	for r.bQuit == false {
-		conn, err := r.listener.Accept()
+	l, err := listenTCP(r.Port)
+	if err != nil {
+		return fail(err)
+	}
+	listeners = append(listeners, l)
+	if r.HttpPort != 0 { /*http requests are taken on the rtsp port too, this is for clients that can only reach port 80*/
+		if l, err = listenTCP(r.HttpPort); err != nil {
+			return fail(err)
+		}
+		listeners = append(listeners, l)
+	}
+	if r.TLSCert != "" && r.TLSKey != "" {
+		certs, err := newCertLoader(r.TLSCert, r.TLSKey)
+		if err != nil {
+			return fail(err)
+		}
+		if l, err = listenTLS(r.TLSPort, certs); err != nil {
+			return fail(err)
+		}
+		listeners = append(listeners, l)
+	}
+	var admin net.Listener
+	if r.AdminAddr != "" {
+		if admin, err = listenAdmin(r.AdminAddr); err != nil {
+			return fail(err)
+		}
+	}
+	r.connLock.Lock()
+	r.closing = false
+	r.listeners = listeners
+	if admin != nil {
+		r.admin = &http.Server{Handler: r.adminHandler()}
+		r.wg.Add(1)
+		go func(s *http.Server) {
+			defer r.wg.Done()
+			if err := s.Serve(admin); err != http.ErrServerClosed {
+				log.Println(err)
+			}
+		}(r.admin)
+	}
+	for _, l := range listeners[1:] {
+		r.wg.Add(1)
+		go func(l net.Listener) {
+			defer r.wg.Done()
+			r.serve(l)
+		}(l)
+	}
+	r.connLock.Unlock()
+	r.sessions.Start()
+	r.serve(listeners[0])
+	return true
+}
+
+func (r *RtspServer) serve(l net.Listener) {
+	var delay time.Duration
+	for {
+		conn, err := l.Accept()
 		if err != nil {
+			if r.isClosing() || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			/*out of file descriptors and the like: back off instead of spinning*/
+			if delay == 0 {
+				delay = time.Millisecond * 5
+			} else if delay < time.Second {
+				delay *= 2
+			}
 			log.Println(err)
+			time.Sleep(delay)
 			continue
 		}
+		delay = 0
 		if tcpConn, ok := conn.(*net.TCPConn); ok {
 			tcpConn.SetReadBuffer(1024 * 50)
 			tcpConn.SetWriteBuffer(1024 * 50)
 		}
 		cc := NewConnection(conn, r)
-		go cc.Start()
+		if err := r.addConn(cc); err != nil {
+			conn.Close()
+			if err == errClosing {
+				return
+			}
+			log.Printf("refuse %v: %v\n", conn.RemoteAddr(), err)
+			continue
+		}
+		go func() {
+			defer r.removeConn(cc)
+			cc.Start()
+		}()
+	}
+}
+
+func (r *RtspServer) isClosing() bool {
+	r.connLock.Lock()
+	defer r.connLock.Unlock()
+	return r.closing
+}
+
+var errClosing = errors.New("server is shutting down")
+
+func (r *RtspServer) addConn(c *ClientConnection) error {
+	r.connLock.Lock()
+	defer r.connLock.Unlock()
+	if r.closing {
+		return errClosing
+	}
+	if r.MaxConns > 0 && len(r.conns) >= r.MaxConns {
+		return fmt.Errorf("%d connections already", len(r.conns))
+	}
+	r.conns[c] = true
+	r.wg.Add(1)
+	return nil
+}
+
+/*empty Transports allows every protocol*/
+func (r *RtspServer) transportAllowed(protocol ProtocolName) bool {
+	r.connLock.Lock()
+	defer r.connLock.Unlock()
+	if len(r.Transports) == 0 {
+		return true
+	}
+	for _, t := range r.Transports {
+		if t == protocol.String() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RtspServer) sessionsFull() bool {
+	r.connLock.Lock()
+	max := r.MaxSessions
+	r.connLock.Unlock()
+	return max > 0 && r.sessions.Count() >= max
+}
+
+func (r *RtspServer) removeConn(c *ClientConnection) {
+	r.connLock.Lock()
+	delete(r.conns, c)
+	r.connLock.Unlock()
+	r.wg.Done()
+}
+
+/*stop accepting, tear down every session and wait for the connections to go until ctx is done*/
+func (r *RtspServer) Shutdown(ctx context.Context) error {
+	r.connLock.Lock()
+	r.closing = true
+	listeners, admin := r.listeners, r.admin
+	r.listeners, r.admin = nil, nil
+	r.connLock.Unlock()
+	for _, l := range listeners {
+		l.Close()
+	}
+	if admin != nil {
+		admin.Close()
+	}
+
+	/*a client that stops reading would block its teardown write, closing the conns fails it*/
+	tornDown := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			r.closeConns()
+		case <-tornDown:
+		}
+	}()
+	for _, s := range r.sessions.Sessions() {
+		s.shutdown()
+	}
+	close(tornDown)
+	r.sessions.Stop()
+	r.closeConns()
+	for _, s := range r.Streams() {
+		s.Close()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Println("server stopped")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *RtspServer) closeConns() {
+	r.connLock.Lock()
+	defer r.connLock.Unlock()
+	for c := range r.conns {
+		c.Conn.Close()
 	}
-	return true
 }
 
 func (r *RtspServer) Stop() {
-	r.bQuit = true
-	r.listener.Close()
+	r.Shutdown(context.Background())
 }
@@ -0,0 +1,496 @@
+// rtsp-session
+package rtsp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*state created by SETUP/ANNOUNCE, it can outlive the tcp connection that created it (udp clients)*/
+type RtspSession struct {
+	ID               string
+	Timeout          time.Duration
+	conn             *ClientConnection
+	stream           *MediaStream
+	transports       map[int]*RtpTransport /*play transports by track*/
+	players          map[int]*FilePlayer
+	rangeEnd         float64 /*npt the file players stop at, 0 for the end of the files*/
+	scale            float64
+	speed            float64
+	live             *LiveSource
+	recordTransports map[int]*RtpTransport
+	recording        bool
+	playing          bool
+	created          time.Time
+	lastActive       time.Time
+	closed           bool
+	manager          *SessionManager
+	lock             sync.Mutex
+}
+
+type SessionManager struct {
+	Timeout  time.Duration
+	sessions map[string]*RtspSession
+	lock     sync.Mutex
+	quit     chan struct{}
+}
+
+func NewSessionManager(timeout time.Duration) *SessionManager {
+	return &SessionManager{
+		Timeout:  timeout,
+		sessions: make(map[string]*RtspSession),
+	}
+}
+
+func newSessionID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%016X", time.Now().UnixNano())
+	}
+	return strings.ToUpper(hex.EncodeToString(b))
+}
+
+func (m *SessionManager) NewSession(c *ClientConnection) *RtspSession {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	id := newSessionID()
+	for m.sessions[id] != nil {
+		id = newSessionID()
+	}
+	s := &RtspSession{
+		ID:               id,
+		Timeout:          m.Timeout,
+		conn:             c,
+		transports:       make(map[int]*RtpTransport),
+		players:          make(map[int]*FilePlayer),
+		scale:            1,
+		speed:            1,
+		recordTransports: make(map[int]*RtpTransport),
+		created:          time.Now(),
+		lastActive:       time.Now(),
+		manager:          m,
+	}
+	m.sessions[id] = s
+	log.Printf("session %s created for %v\n", id, c.Conn.RemoteAddr())
+	return s
+}
+
+/*sessions made from now on get the new timeout, running ones keep theirs*/
+func (m *SessionManager) SetTimeout(timeout time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.Timeout = timeout
+}
+
+func (m *SessionManager) Count() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return len(m.sessions)
+}
+
+/*"Session: id;timeout=60" -> id*/
+func (m *SessionManager) Get(header string) *RtspSession {
+	id := strings.TrimSpace(strings.Split(header, ";")[0])
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.sessions[id]
+}
+
+func (m *SessionManager) Remove(s *RtspSession) {
+	m.lock.Lock()
+	delete(m.sessions, s.ID)
+	m.lock.Unlock()
+	s.Close()
+}
+
+func (m *SessionManager) Sessions() []*RtspSession {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	ss := make([]*RtspSession, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		ss = append(ss, s)
+	}
+	return ss
+}
+
+func (m *SessionManager) Start() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.quit != nil {
+		return
+	}
+	m.quit = make(chan struct{})
+	go m.reap(m.quit)
+}
+
+func (m *SessionManager) Stop() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.quit != nil {
+		close(m.quit)
+		m.quit = nil
+	}
+}
+
+func (m *SessionManager) reap(quit chan struct{}) {
+	ticker := time.NewTicker(time.Second * 5)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+		}
+		for _, s := range m.Sessions() {
+			if s.Expired() {
+				log.Printf("session %s timeout\n", s.ID)
+				m.Remove(s)
+			}
+		}
+	}
+}
+
+func (s *RtspSession) Header() string {
+	return fmt.Sprintf("%s;timeout=%d", s.ID, int(s.Timeout/time.Second))
+}
+
+func (s *RtspSession) touch() {
+	s.lock.Lock()
+	s.lastActive = time.Now()
+	s.lock.Unlock()
+}
+
+/*interleaved sessions live as long as their tcp connection, others need keepalives*/
+func (s *RtspSession) Expired() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.isInterleaved() {
+		return false
+	}
+	return time.Since(s.lastActive) > s.Timeout
+}
+
+func (s *RtspSession) isInterleaved() bool {
+	for _, t := range s.transports {
+		if t.Protocol == TCP {
+			return true
+		}
+	}
+	for _, t := range s.recordTransports {
+		if t.Protocol == TCP {
+			return true
+		}
+	}
+	return false
+}
+
+/*one SETUP per track, a SETUP on another mount starts over*/
+func (s *RtspSession) setTransport(stream *MediaStream, t *RtpTransport) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.stream != stream {
+		s.closeTransports()
+	} else if old, ok := s.transports[t.Track]; ok {
+		if p, ok := s.players[t.Track]; ok {
+			p.Stop()
+			delete(s.players, t.Track)
+		}
+		old.Close()
+	}
+	s.stream = stream
+	s.transports[t.Track] = t
+	t.session = s
+}
+
+func (s *RtspSession) hasTransports() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.transports) > 0
+}
+
+/*file tracks get their own player, created once so PAUSE/PLAY resumes it*/
+func (s *RtspSession) preparePlay() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.transports) == 0 {
+		return fmt.Errorf("session %s has no transport", s.ID)
+	}
+	if s.stream.IsLive() {
+		if s.stream.AcquireLive() == nil {
+			return fmt.Errorf("mount %s is not live", s.stream.Path)
+		}
+		return nil
+	}
+	for track, t := range s.transports {
+		if t.Protocol == Multicast || s.players[track] != nil {
+			continue
+		}
+		player, err := s.stream.NewPlayer(track, t)
+		if err != nil {
+			return err
+		}
+		s.players[track] = player
+	}
+	return nil
+}
+
+/*all tracks start from the same instant so they stay in sync*/
+func (s *RtspSession) startPlay() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	s.playing = true
+	live := s.stream.Live()
+	for track, t := range s.transports {
+		if t.Protocol == Multicast {
+			t.JoinGroup()
+		} else if live != nil {
+			t.JoinLive(live)
+		} else if p := s.players[track]; p != nil && (s.scale == 1 || p.IsVideo()) {
+			p.PlayAt(now)
+		}
+	}
+}
+
+func (s *RtspSession) pausePlay() {
+	s.lock.Lock()
+	s.playing = false
+	for _, t := range s.transports {
+		t.LeaveLive()
+		t.LeaveGroup()
+	}
+	s.lock.Unlock()
+	s.pausePlayers()
+}
+
+/*Pause waits for a write in flight, which a slow tcp client can hold up, so it runs without s.lock*/
+func (s *RtspSession) pausePlayers() {
+	s.lock.Lock()
+	players := make([]*FilePlayer, 0, len(s.players))
+	for _, p := range s.players {
+		players = append(players, p)
+	}
+	s.lock.Unlock()
+	for _, p := range players {
+		p.Pause()
+	}
+}
+
+func (s *RtspSession) hasVideo() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, p := range s.players {
+		if p.IsVideo() {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *RtspSession) rate() (float64, float64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.scale, s.speed
+}
+
+/*trick play of the file tracks; a new scale restarts every track from where the first one is, audio stays muted unless the scale is 1*/
+func (s *RtspSession) setRate(scale float64, speed float64) {
+	s.pausePlayers()
+	s.lock.Lock()
+	changed := scale != s.scale
+	s.scale, s.speed = scale, speed
+	npt := -1.0
+	for track := 0; track < len(s.stream.FileTracks()); track++ {
+		if p := s.players[track]; p != nil {
+			p.SetRate(scale, speed)
+			if _, _, pos := p.Position(); npt < 0 {
+				npt = pos
+			}
+		}
+	}
+	end := s.rangeEnd
+	s.lock.Unlock()
+	if changed && npt >= 0 {
+		s.seek(npt, end)
+	}
+}
+
+/*file tracks move to the same instant: video to its keyframe, the others to where video landed*/
+func (s *RtspSession) seek(npt float64, end float64) float64 {
+	s.pausePlayers()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.rangeEnd = end
+	seeked := false
+	for track := 0; track < len(s.stream.FileTracks()); track++ {
+		if p := s.players[track]; p != nil {
+			if !seeked {
+				npt, seeked = p.Seek(npt, end), true
+			} else {
+				p.Seek(npt, end)
+			}
+		}
+	}
+	return npt
+}
+
+/*Range npt and RTP-Info of the file tracks, for the PLAY response*/
+func (s *RtspSession) playPosition(baseUrl string) (float64, float64, string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	npt := 0.0
+	var infos []string
+	for track := 0; track < len(s.stream.FileTracks()); track++ {
+		p := s.players[track]
+		if p == nil {
+			continue
+		}
+		seq, rtpTime, pos := p.Position()
+		if len(infos) == 0 {
+			npt = pos
+		}
+		infos = append(infos, fmt.Sprintf("url=%s/trackID=%d;seq=%d;rtptime=%d", baseUrl, track, seq, rtpTime))
+	}
+	return npt, s.rangeEnd, strings.Join(infos, ",")
+}
+
+func (s *RtspSession) closeTransports() {
+	for track, p := range s.players {
+		p.Stop()
+		delete(s.players, track)
+	}
+	for track, t := range s.transports {
+		t.Close()
+		delete(s.transports, track)
+	}
+}
+
+func (s *RtspSession) startPublish(stream *MediaStream, tracks []*LiveTrack) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	live, err := stream.StartPublish(tracks, s)
+	if err != nil {
+		return err
+	}
+	s.stream = stream
+	s.live = live
+	log.Printf("publish start %s tracks:%d\n", stream.Path, len(tracks))
+	return nil
+}
+
+func (s *RtspSession) setRecordTransport(track int, t *RtpTransport) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if old, ok := s.recordTransports[track]; ok {
+		old.Close()
+	}
+	t.session = s
+	s.recordTransports[track] = t
+}
+
+func (s *RtspSession) startRecord() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.live == nil || len(s.recordTransports) == 0 {
+		return false
+	}
+	s.recording = true
+	return true
+}
+
+/*interleaved packet from the control connection, returns false if it is not ours*/
+func (s *RtspSession) onInterleaved(channel int, data []byte) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for track, t := range s.recordTransports {
+		if channel == t.RtpChannel {
+			if s.recording {
+				s.live.WritePacket(track, data)
+			}
+			return true
+		} else if channel == t.RtcpChannel {
+			logRtcpPacket(data)
+			if s.recording {
+				s.live.WriteRTCP(track, data)
+			}
+			return true
+		}
+	}
+	for _, t := range s.transports {
+		if t.Protocol == TCP && channel == t.RtcpChannel {
+			logRtcpPacket(data)
+			return true
+		}
+	}
+	return false
+}
+
+func (s *RtspSession) writeRecorded(track int, pkt []byte, rtcp bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.recording {
+		return
+	}
+	if rtcp {
+		s.live.WriteRTCP(track, pkt)
+	} else {
+		s.live.WritePacket(track, pkt)
+	}
+}
+
+func (s *RtspSession) stopPublish() {
+	s.recording = false
+	for track, t := range s.recordTransports {
+		t.Close()
+		delete(s.recordTransports, track)
+	}
+	if s.live == nil {
+		return
+	}
+	s.live = nil
+	log.Printf("publish stop %s\n", s.stream.Path)
+	if s.conn != nil {
+		s.conn.rtsp.notifyHooks(s.conn.hookEvent(HookPublishStop, s.stream.Path, s, ""))
+	}
+	if s.stream.StopPublish(s) && s.conn != nil {
+		s.conn.rtsp.RemoveStream(s.stream.Path)
+	}
+}
+
+/*server going away: stop sending, BYE on every stream, TEARDOWN to the client*/
+func (s *RtspSession) shutdown() {
+	s.pausePlayers()
+	s.lock.Lock()
+	for _, t := range s.transports {
+		t.LeaveLive()
+		t.LeaveGroup()
+		if err := t.SendBye(); err != nil {
+			log.Println(err)
+		}
+	}
+	conn, stream := s.conn, s.stream
+	s.lock.Unlock()
+	if conn != nil && stream != nil {
+		if err := conn.sendTeardown(stream.Path, s); err != nil {
+			log.Println(err)
+		}
+	}
+	s.manager.Remove(s)
+}
+
+func (s *RtspSession) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.closeTransports()
+	s.stopPublish()
+	log.Printf("session %s closed\n", s.ID)
+}
@@ -0,0 +1,118 @@
+// sdp-builder
+package rtsp
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"time"
+)
+
+/*what DESCRIBE needs to know about a source*/
+type StreamInfo struct {
+	MediaType   string
+	Codec       string
+	PayloadType int
+	VPS         []byte
+	SPS         []byte
+	PPS         []byte
+	SampleRate  int
+	Channels    int
+	Config      string
+	Duration    float64 /*seconds, 0 while unknown*/
+}
+
+/*first parameter sets and length of a file, frames last as long as FilePlayer paces them*/
+func probeFile(fileName string, frameRate float64) (*StreamInfo, error) {
+	if audioCodec(fileName) != "" {
+		return probeAudio(fileName)
+	}
+	mf := NewMediaFileSource()
+	if err := mf.ReadFileData(fileName); err != nil {
+		return nil, err
+	}
+	info := &StreamInfo{MediaType: "video", Codec: mf.Codec, PayloadType: 96}
+	vps, sps, pps := byte(32), byte(33), byte(34)
+	if mf.Codec != CodecH265 {
+		vps, sps, pps = 0xff, 7, 8
+	}
+	v := newVideoReader(mf, frameRate)
+	ticks := uint64(0)
+	for nalu := mf.GetNextNalu(); nalu != nil; nalu = mf.GetNextNalu() {
+		if len(nalu) == 0 {
+			continue
+		}
+		switch naluType(mf.Codec, nalu) {
+		case vps:
+			if info.VPS == nil {
+				info.VPS = nalu
+			}
+		case sps:
+			if info.SPS == nil {
+				info.SPS = nalu
+			}
+		case pps:
+			if info.PPS == nil {
+				info.PPS = nalu
+			}
+		}
+		v.observe(nalu)
+		if isFrameEnd(mf.Codec, nalu, mf.PeekNextNalu()) {
+			ticks += uint64(v.frameDuration)
+		}
+	}
+	info.Duration = float64(ticks) / 90000
+	return info, nil
+}
+
+/*m= section of one track*/
+func (info *StreamInfo) mediaSDP(control string) string {
+	b64 := base64.StdEncoding.EncodeToString
+	payloadType := info.PayloadType
+	media := fmt.Sprintf("m=%s 0 RTP/AVP %d\r\n", info.MediaType, payloadType)
+	switch info.Codec {
+	case CodecAAC:
+		media += fmt.Sprintf("a=rtpmap:%d MPEG4-GENERIC/%d/%d\r\n", payloadType, info.SampleRate, info.Channels)
+		media += fmt.Sprintf("a=fmtp:%d streamtype=5;profile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3;config=%s\r\n",
+			payloadType, info.Config)
+	case CodecPCMU, CodecPCMA:
+		media += fmt.Sprintf("a=rtpmap:%d %s/8000\r\n", payloadType, info.Codec)
+	case CodecH265:
+		media += fmt.Sprintf("a=rtpmap:%d H265/90000\r\n", payloadType)
+		if info.VPS != nil && info.SPS != nil && info.PPS != nil {
+			media += fmt.Sprintf("a=fmtp:%d sprop-vps=%s;sprop-sps=%s;sprop-pps=%s\r\n", payloadType,
+				b64(info.VPS), b64(info.SPS), b64(info.PPS))
+		}
+	default:
+		media += fmt.Sprintf("a=rtpmap:%d H264/90000\r\n", payloadType)
+		fmtp := "packetization-mode=1"
+		if len(info.SPS) >= 4 {
+			fmtp += fmt.Sprintf(";profile-level-id=%02X%02X%02X", info.SPS[1], info.SPS[2], info.SPS[3])
+		}
+		if info.SPS != nil && info.PPS != nil {
+			fmtp += fmt.Sprintf(";sprop-parameter-sets=%s,%s", b64(info.SPS), b64(info.PPS))
+		}
+		media += fmt.Sprintf("a=fmtp:%d %s\r\n", payloadType, fmtp)
+	}
+	return media + fmt.Sprintf("a=control:%s\r\n", control)
+}
+
+/*session level description around the m= sections, duration 0 means live*/
+func buildSDP(ip net.IP, name string, duration float64, media string) string {
+	addrType, anyAddr := "IP4", "0.0.0.0"
+	if ip.To4() == nil {
+		addrType, anyAddr = "IP6", "::"
+	}
+	rng := "a=range:npt=0-\r\n"
+	if duration > 0 {
+		rng = fmt.Sprintf("a=range:npt=0-%.3f\r\n", duration)
+	}
+	return fmt.Sprintf("v=0\r\n"+
+		"o=- %d %d IN %s %s\r\n"+
+		"s=%s\r\n"+
+		"c=IN %s %s\r\n"+
+		"t=0 0\r\n"+
+		"a=control:*\r\n"+
+		"%s"+
+		"%s", time.Now().Unix(), time.Now().Unix(), addrType, ip, name, addrType, anyAddr, rng, media)
+}
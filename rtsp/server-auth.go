@@ -0,0 +1,178 @@
+// server-auth
+package rtsp
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+type UserConfig struct {
+	UserName string `mapstructure:"username" json:"username"`
+	Password string `mapstructure:"password" json:"password"`
+}
+
+var errStaleNonce = errors.New("stale nonce")
+
+/*checks the Authorization header of incoming requests, disabled while there are no users*/
+type ServerAuth struct {
+	Realm        string
+	Basic        bool
+	NonceTimeout time.Duration
+	users        map[string]string
+	nonces       map[string]time.Time
+	lock         sync.Mutex
+}
+
+func NewServerAuth(realm string) *ServerAuth {
+	return &ServerAuth{
+		Realm:        realm,
+		Basic:        false,
+		NonceTimeout: time.Second * 60,
+		users:        make(map[string]string),
+		nonces:       make(map[string]time.Time),
+	}
+}
+
+func (a *ServerAuth) AddUser(name string, password string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.users[name] = password
+}
+
+/*replaces every user at once, as a config reload does*/
+func (a *ServerAuth) SetUsers(users map[string]string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.users = users
+}
+
+func (a *ServerAuth) Enabled() bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return len(a.users) > 0
+}
+
+func (a *ServerAuth) newNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	nonce := hex.EncodeToString(b)
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for n, t := range a.nonces {
+		if time.Since(t) > a.NonceTimeout {
+			delete(a.nonces, n)
+		}
+	}
+	a.nonces[nonce] = time.Now()
+	return nonce
+}
+
+/*realm, basic and nonce timeout may be set while requests are being checked*/
+func (a *ServerAuth) Configure(realm string, basic bool, nonceTimeout time.Duration) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.Realm, a.Basic, a.NonceTimeout = realm, basic, nonceTimeout
+}
+
+func (a *ServerAuth) settings() (string, bool, time.Duration) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.Realm, a.Basic, a.NonceTimeout
+}
+
+/*WWW-Authenticate header lines for a 401*/
+func (a *ServerAuth) Challenge(stale bool) string {
+	realm, basic, _ := a.settings()
+	challenge := fmt.Sprintf("WWW-Authenticate: Digest realm=\"%s\", nonce=\"%s\"", realm, a.newNonce())
+	if stale {
+		challenge += ", stale=TRUE"
+	}
+	challenge += "\r\n"
+	if basic {
+		challenge += fmt.Sprintf("WWW-Authenticate: Basic realm=\"%s\"\r\n", realm)
+	}
+	return challenge
+}
+
+/*returns the authenticated user name, requestUrl is what a digest uri has to match*/
+func (a *ServerAuth) Verify(method string, requestUrl string, authorization string) (string, error) {
+	realm, basic, nonceTimeout := a.settings()
+	if strings.HasPrefix(authorization, "Basic ") {
+		if !basic {
+			return "", errors.New("basic authentication is disabled")
+		}
+		userPwd, err := base64.StdEncoding.DecodeString(strings.TrimSpace(authorization[6:]))
+		if err != nil {
+			return "", err
+		}
+		items := strings.SplitN(string(userPwd), ":", 2)
+		if len(items) != 2 {
+			return "", errors.New("malformed basic credentials")
+		}
+		a.lock.Lock()
+		password, ok := a.users[items[0]]
+		a.lock.Unlock()
+		if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(items[1])) != 1 {
+			return "", fmt.Errorf("bad password for %s", items[0])
+		}
+		return items[0], nil
+	}
+	if !strings.HasPrefix(authorization, "Digest ") {
+		return "", errors.New("no credentials")
+	}
+
+	params := parseAuthParams(authorization[7:])
+	userName := params["username"]
+	a.lock.Lock()
+	password, ok := a.users[userName]
+	issued, known := a.nonces[params["nonce"]]
+	a.lock.Unlock()
+	if !ok {
+		return "", fmt.Errorf("unknown user %s", userName)
+	}
+	if params["realm"] != realm {
+		return "", fmt.Errorf("wrong realm %s", params["realm"])
+	}
+	if !known || time.Since(issued) > nonceTimeout {
+		return "", errStaleNonce
+	}
+	/*the response only covers uri, one captured for another mount must not do*/
+	if params["uri"] != requestUrl && urlMountPath(params["uri"]) != urlMountPath(requestUrl) {
+		return "", fmt.Errorf("digest uri %s does not match %s", params["uri"], requestUrl)
+	}
+	auth := &DigestAuth{
+		UserName: userName,
+		Password: password,
+		Realm:    realm,
+		Nonce:    params["nonce"],
+	}
+	expected := auth.computeDigestResponse(method, params["uri"])
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(params["response"])) != 1 {
+		return "", fmt.Errorf("bad digest response for %s", userName)
+	}
+	return userName, nil
+}
+
+var authorizationLine = regexp.MustCompile(`(?im)^(Authorization:[ \t]*\w+)[^\r\n]*`)
+
+/*request text for logs: basic credentials decode to the password, so the whole header value goes*/
+func redactAuthorization(s string) string {
+	return authorizationLine.ReplaceAllString(s, "$1 ***")
+}
+
+/*username="a", realm="b",nonce="c" -> map*/
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for _, m := range regexp.MustCompile(`(\w+)=(?:"([^"]*)"|([^,\s]*))`).FindAllStringSubmatch(s, -1) {
+		params[m[1]] = m[2] + m[3]
+	}
+	return params
+}
@@ -0,0 +1,357 @@
+// sps-parser
+package rtsp
+
+/*msb first reader over an rbsp, reads past the end return zeros and set overrun*/
+type bitReader struct {
+	data    []byte
+	pos     int
+	overrun bool
+}
+
+/*drop the emulation prevention byte of every 00 00 03*/
+func naluToRBSP(nalu []byte) []byte {
+	rbsp := make([]byte, 0, len(nalu))
+	zeros := 0
+	for _, b := range nalu {
+		if zeros >= 2 && b == 0x03 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		rbsp = append(rbsp, b)
+	}
+	return rbsp
+}
+
+func (r *bitReader) u(n int) uint32 {
+	var v uint32
+	for i := 0; i < n; i++ {
+		if r.pos >= len(r.data)*8 {
+			r.overrun = true
+			return 0
+		}
+		bit := (r.data[r.pos/8] >> (7 - uint(r.pos%8))) & 1
+		v = v<<1 | uint32(bit)
+		r.pos++
+	}
+	return v
+}
+
+func (r *bitReader) flag() bool {
+	return r.u(1) == 1
+}
+
+func (r *bitReader) skip(n int) {
+	r.pos += n
+	if r.pos > len(r.data)*8 {
+		r.overrun = true
+	}
+}
+
+/*exp-golomb*/
+func (r *bitReader) ue() uint32 {
+	zeros := 0
+	for !r.flag() {
+		if r.overrun || zeros > 31 {
+			r.overrun = true
+			return 0
+		}
+		zeros++
+	}
+	return (1<<uint(zeros) - 1) + r.u(zeros)
+}
+
+func (r *bitReader) se() int32 {
+	v := r.ue()
+	if v&1 == 1 {
+		return int32((v + 1) / 2)
+	}
+	return -int32(v / 2)
+}
+
+/*frames per second signalled by a parameter set, 0 when it is not one or carries no timing info*/
+func paramSetFrameRate(codec string, nalu []byte) float64 {
+	if len(nalu) < 4 {
+		return 0
+	}
+	if codec == CodecH265 {
+		switch naluType(codec, nalu) {
+		case 32:
+			return h265VPSFrameRate(naluToRBSP(nalu[2:]))
+		case 33:
+			return h265SPSFrameRate(naluToRBSP(nalu[2:]))
+		}
+		return 0
+	}
+	if naluType(codec, nalu) == 7 {
+		return h264SPSFrameRate(naluToRBSP(nalu[1:]))
+	}
+	return 0
+}
+
+func timingFrameRate(r *bitReader, ticksPerFrame uint32) float64 {
+	numUnitsInTick := r.u(32)
+	timeScale := r.u(32)
+	if r.overrun || numUnitsInTick == 0 || timeScale == 0 {
+		return 0
+	}
+	return float64(timeScale) / (float64(numUnitsInTick) * float64(ticksPerFrame))
+}
+
+/*7.3.2.1.1 seq_parameter_set_data up to vui timing_info*/
+func h264SPSFrameRate(rbsp []byte) float64 {
+	r := &bitReader{data: rbsp}
+	profile := r.u(8)
+	r.skip(16) /*constraint flags, level_idc*/
+	r.ue()     /*seq_parameter_set_id*/
+	switch profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		lists := 8
+		if r.ue() == 3 { /*chroma_format_idc*/
+			r.skip(1)
+			lists = 12
+		}
+		r.ue()
+		r.ue()
+		r.skip(1)
+		if r.flag() { /*seq_scaling_matrix_present_flag*/
+			for i := 0; i < lists; i++ {
+				if !r.flag() {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				last, next := int32(8), int32(8)
+				for j := 0; j < size && next != 0; j++ {
+					next = (last + r.se() + 256) % 256
+					if next != 0 {
+						last = next
+					}
+				}
+			}
+		}
+	}
+	r.ue()          /*log2_max_frame_num_minus4*/
+	switch r.ue() { /*pic_order_cnt_type*/
+	case 0:
+		r.ue()
+	case 1:
+		r.skip(1)
+		r.se()
+		r.se()
+		for n := r.ue(); n > 0 && !r.overrun; n-- {
+			r.se()
+		}
+	}
+	r.ue()    /*max_num_ref_frames*/
+	r.skip(1) /*gaps_in_frame_num_value_allowed_flag*/
+	r.ue()
+	r.ue()
+	if !r.flag() { /*frame_mbs_only_flag*/
+		r.skip(1)
+	}
+	r.skip(1)     /*direct_8x8_inference_flag*/
+	if r.flag() { /*frame_cropping_flag*/
+		r.ue()
+		r.ue()
+		r.ue()
+		r.ue()
+	}
+	if !r.flag() || r.overrun { /*vui_parameters_present_flag*/
+		return 0
+	}
+	skipVUIHead(r)
+	if !r.flag() { /*timing_info_present_flag*/
+		return 0
+	}
+	return timingFrameRate(r, 2) /*time_scale counts fields*/
+}
+
+/*aspect ratio, overscan, video signal type and chroma location: the part of the vui both codecs share*/
+func skipVUIHead(r *bitReader) {
+	if r.flag() { /*aspect_ratio_info_present_flag*/
+		if r.u(8) == 255 {
+			r.skip(32)
+		}
+	}
+	if r.flag() { /*overscan_info_present_flag*/
+		r.skip(1)
+	}
+	if r.flag() { /*video_signal_type_present_flag*/
+		r.skip(4)
+		if r.flag() {
+			r.skip(24)
+		}
+	}
+	if r.flag() { /*chroma_loc_info_present_flag*/
+		r.ue()
+		r.ue()
+	}
+}
+
+/*7.3.3 profile_tier_level*/
+func skipProfileTierLevel(r *bitReader, maxSubLayersMinus1 int) {
+	r.skip(96)
+	profilePresent := make([]bool, maxSubLayersMinus1)
+	levelPresent := make([]bool, maxSubLayersMinus1)
+	for i := 0; i < maxSubLayersMinus1; i++ {
+		profilePresent[i] = r.flag()
+		levelPresent[i] = r.flag()
+	}
+	if maxSubLayersMinus1 > 0 {
+		r.skip(2 * (8 - maxSubLayersMinus1))
+	}
+	for i := 0; i < maxSubLayersMinus1; i++ {
+		if profilePresent[i] {
+			r.skip(88)
+		}
+		if levelPresent[i] {
+			r.skip(8)
+		}
+	}
+}
+
+/*7.3.2.1 video_parameter_set_rbsp up to vps_timing_info*/
+func h265VPSFrameRate(rbsp []byte) float64 {
+	r := &bitReader{data: rbsp}
+	r.skip(12) /*vps id, base layer flags, max_layers_minus1*/
+	maxSubLayersMinus1 := int(r.u(3))
+	r.skip(17)
+	skipProfileTierLevel(r, maxSubLayersMinus1)
+	i := maxSubLayersMinus1
+	if r.flag() { /*vps_sub_layer_ordering_info_present_flag*/
+		i = 0
+	}
+	for ; i <= maxSubLayersMinus1; i++ {
+		r.ue()
+		r.ue()
+		r.ue()
+	}
+	maxLayerID := int(r.u(6))
+	for n := r.ue(); n > 0 && !r.overrun; n-- {
+		r.skip(maxLayerID + 1)
+	}
+	if !r.flag() || r.overrun { /*vps_timing_info_present_flag*/
+		return 0
+	}
+	return timingFrameRate(r, 1)
+}
+
+/*7.3.2.2 seq_parameter_set_rbsp up to vui timing_info*/
+func h265SPSFrameRate(rbsp []byte) float64 {
+	r := &bitReader{data: rbsp}
+	r.skip(4)
+	maxSubLayersMinus1 := int(r.u(3))
+	r.skip(1)
+	skipProfileTierLevel(r, maxSubLayersMinus1)
+	r.ue()
+	if r.ue() == 3 { /*chroma_format_idc*/
+		r.skip(1)
+	}
+	r.ue()
+	r.ue()
+	if r.flag() { /*conformance_window_flag*/
+		r.ue()
+		r.ue()
+		r.ue()
+		r.ue()
+	}
+	r.ue()
+	r.ue()
+	pocLsbBits := int(r.ue()) + 4
+	i := maxSubLayersMinus1
+	if r.flag() { /*sps_sub_layer_ordering_info_present_flag*/
+		i = 0
+	}
+	for ; i <= maxSubLayersMinus1; i++ {
+		r.ue()
+		r.ue()
+		r.ue()
+	}
+	for n := 0; n < 6; n++ { /*coding, transform block sizes and depths*/
+		r.ue()
+	}
+	if r.flag() && r.flag() { /*scaling_list_enabled_flag, sps_scaling_list_data_present_flag*/
+		for sizeID := 0; sizeID < 4; sizeID++ {
+			for matrixID := 0; matrixID < 6; matrixID++ {
+				if sizeID == 3 && matrixID%3 != 0 {
+					continue
+				}
+				if !r.flag() { /*scaling_list_pred_mode_flag*/
+					r.ue()
+					continue
+				}
+				coefs := 64
+				if sizeID == 0 {
+					coefs = 16
+				}
+				if sizeID > 1 {
+					r.se()
+				}
+				for j := 0; j < coefs; j++ {
+					r.se()
+				}
+			}
+		}
+	}
+	r.skip(2)     /*amp, sample_adaptive_offset*/
+	if r.flag() { /*pcm_enabled_flag*/
+		r.skip(8)
+		r.ue()
+		r.ue()
+		r.skip(1)
+	}
+	sets := int(r.ue())
+	if sets > 64 {
+		return 0
+	}
+	deltaPocs := make([]int, sets)
+	for idx := 0; idx < sets && !r.overrun; idx++ { /*st_ref_pic_set*/
+		if idx != 0 && r.flag() { /*inter_ref_pic_set_prediction_flag*/
+			r.skip(1)
+			r.ue()
+			for j := 0; j <= deltaPocs[idx-1]; j++ {
+				if r.flag() || r.flag() { /*used_by_curr_pic_flag, use_delta_flag*/
+					deltaPocs[idx]++
+				}
+			}
+			continue
+		}
+		negative, positive := int(r.ue()), int(r.ue())
+		if negative+positive > 32 {
+			return 0
+		}
+		for j := 0; j < negative+positive; j++ {
+			r.ue()
+			r.skip(1)
+		}
+		deltaPocs[idx] = negative + positive
+	}
+	if r.flag() { /*long_term_ref_pics_present_flag*/
+		for n := r.ue(); n > 0 && !r.overrun; n-- {
+			r.skip(pocLsbBits + 1)
+		}
+	}
+	r.skip(2)                   /*temporal_mvp, strong_intra_smoothing*/
+	if !r.flag() || r.overrun { /*vui_parameters_present_flag*/
+		return 0
+	}
+	skipVUIHead(r)
+	r.skip(3)     /*neutral_chroma, field_seq, frame_field_info*/
+	if r.flag() { /*default_display_window_flag*/
+		r.ue()
+		r.ue()
+		r.ue()
+		r.ue()
+	}
+	if !r.flag() { /*vui_timing_info_present_flag*/
+		return 0
+	}
+	return timingFrameRate(r, 1)
+}
@@ -0,0 +1,377 @@
+// sps-parser_test
+package rtsp
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+/*msb first writer, the inverse of bitReader*/
+type bitWriter struct {
+	data []byte
+	pos  int
+}
+
+func (w *bitWriter) u(n int, v uint64) *bitWriter {
+	for i := n - 1; i >= 0; i-- {
+		if w.pos%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		if v>>uint(i)&1 == 1 {
+			w.data[len(w.data)-1] |= 1 << uint(7-w.pos%8)
+		}
+		w.pos++
+	}
+	return w
+}
+
+func (w *bitWriter) flag(b bool) *bitWriter {
+	if b {
+		return w.u(1, 1)
+	}
+	return w.u(1, 0)
+}
+
+func (w *bitWriter) ue(v uint64) *bitWriter {
+	v++
+	n := 0
+	for t := v; t > 1; t >>= 1 {
+		n++
+	}
+	return w.u(n, 0).u(n+1, v)
+}
+
+func (w *bitWriter) se(v int64) *bitWriter {
+	if v > 0 {
+		return w.ue(uint64(2*v - 1))
+	}
+	return w.ue(uint64(-2 * v))
+}
+
+/*rbsp trailing bits, then the emulation prevention bytes a real encoder inserts*/
+func (w *bitWriter) nalu() []byte {
+	w.u(1, 1)
+	for w.pos%8 != 0 {
+		w.u(1, 0)
+	}
+	var out []byte
+	zeros := 0
+	for _, b := range w.data {
+		if zeros >= 2 && b <= 3 {
+			out = append(out, 3)
+			zeros = 0
+		}
+		out = append(out, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return out
+}
+
+type vuiHead struct {
+	aspect, overscan, signal, chroma bool
+}
+
+func (w *bitWriter) vuiHead(v vuiHead) *bitWriter {
+	if w.flag(v.aspect); v.aspect {
+		w.u(8, 255).u(16, 4).u(16, 3) /*extended sar*/
+	}
+	if w.flag(v.overscan); v.overscan {
+		w.u(1, 1)
+	}
+	if w.flag(v.signal); v.signal {
+		w.u(3, 5).u(1, 0).flag(true).u(24, 0x010101)
+	}
+	if w.flag(v.chroma); v.chroma {
+		w.ue(1).ue(1)
+	}
+	return w
+}
+
+type h264SPS struct {
+	profile  uint64
+	scaling  bool /*high profile scaling matrix*/
+	pocType  uint64
+	cropping bool
+	vui      bool
+	head     vuiHead
+	timing   bool
+	units    uint64
+	scale    uint64
+}
+
+func (p h264SPS) nalu() []byte {
+	w := &bitWriter{}
+	w.u(8, 0x67).u(8, p.profile).u(8, 0).u(8, 31).ue(0)
+	if p.profile == 100 {
+		w.ue(1).ue(0).ue(0).u(1, 0)
+		if w.flag(p.scaling); p.scaling {
+			for i := 0; i < 8; i++ {
+				if w.flag(i == 0 || i == 6); i == 0 {
+					for j := 0; j < 16; j++ {
+						w.se(1)
+					}
+				} else if i == 6 {
+					w.se(-8) /*next scale 0: the list stops and takes the default*/
+				}
+			}
+		}
+	}
+	w.ue(0).ue(p.pocType)
+	switch p.pocType {
+	case 0:
+		w.ue(2)
+	case 1:
+		w.u(1, 0).se(-1).se(2).ue(2).se(1).se(-1)
+	}
+	w.ue(1).u(1, 0).ue(79).ue(44).flag(true).u(1, 1)
+	if w.flag(p.cropping); p.cropping {
+		w.ue(0).ue(0).ue(0).ue(4)
+	}
+	if w.flag(p.vui); p.vui {
+		w.vuiHead(p.head)
+		if w.flag(p.timing); p.timing {
+			w.u(32, p.units).u(32, p.scale).u(1, 1)
+		}
+		w.u(5, 0)
+	}
+	return w.nalu()
+}
+
+func TestH264SPSFrameRate(t *testing.T) {
+	full := vuiHead{aspect: true, overscan: true, signal: true, chroma: true}
+	tests := []struct {
+		name string
+		sps  h264SPS
+		want float64
+	}{
+		{"baseline 25", h264SPS{profile: 66, vui: true, timing: true, units: 1, scale: 50}, 25},
+		{"ntsc 29.97", h264SPS{profile: 66, vui: true, timing: true, units: 1001, scale: 60000}, 30000.0 / 1001},
+		{"high with scaling lists", h264SPS{profile: 100, scaling: true, vui: true, timing: true, units: 1, scale: 120}, 60},
+		{"poc type 1", h264SPS{profile: 77, pocType: 1, vui: true, timing: true, units: 1, scale: 48}, 24},
+		{"poc type 2", h264SPS{profile: 66, pocType: 2, vui: true, timing: true, units: 1, scale: 50}, 25},
+		{"whole vui head and cropping", h264SPS{profile: 100, cropping: true, vui: true, head: full, timing: true, units: 1001, scale: 48000}, 24000.0 / 1001},
+		{"no vui", h264SPS{profile: 66}, 0},
+		{"vui without timing", h264SPS{profile: 66, vui: true, head: full}, 0},
+		{"zero time scale", h264SPS{profile: 66, vui: true, timing: true, units: 1, scale: 0}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := paramSetFrameRate(CodecH264, tt.sps.nalu())
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("frame rate %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+type h265SPS struct {
+	subLayers  uint64 /*sps_max_sub_layers_minus1*/
+	scaling    bool
+	refSets    bool /*two short term sets, the second predicted from the first*/
+	longTerm   bool
+	vui        bool
+	head       vuiHead
+	display    bool
+	timing     bool
+	units      uint64
+	timeScale  uint64
+	conformant bool
+}
+
+/*profile_tier_level with every sub layer profile and level present*/
+func (w *bitWriter) profileTierLevel(subLayers uint64) *bitWriter {
+	w.u(8, 0x01).u(32, 0x60000000).u(48, 0).u(8, 93)
+	for i := uint64(0); i < subLayers; i++ {
+		w.u(1, 1).u(1, 1)
+	}
+	if subLayers > 0 {
+		for i := subLayers; i < 8; i++ {
+			w.u(2, 0)
+		}
+	}
+	for i := uint64(0); i < subLayers; i++ {
+		w.u(88, 0).u(8, 90)
+	}
+	return w
+}
+
+func (p h265SPS) nalu() []byte {
+	w := &bitWriter{}
+	w.u(16, 0x4201).u(4, 0).u(3, p.subLayers).u(1, 1)
+	w.profileTierLevel(p.subLayers)
+	w.ue(0).ue(1).ue(1920).ue(1080)
+	if w.flag(p.conformant); p.conformant {
+		w.ue(0).ue(0).ue(0).ue(4)
+	}
+	w.ue(0).ue(0).ue(4)
+	w.u(1, 0) /*ordering info for the highest sub layer only*/
+	w.ue(4).ue(0).ue(0)
+	w.ue(0).ue(3).ue(0).ue(3).ue(1).ue(1)
+	if w.flag(p.scaling); p.scaling {
+		w.u(1, 1)
+		for sizeID := 0; sizeID < 4; sizeID++ {
+			for matrixID := 0; matrixID < 6; matrixID++ {
+				if sizeID == 3 && matrixID%3 != 0 {
+					continue
+				}
+				if matrixID == 0 { /*explicit list*/
+					w.u(1, 1)
+					coefs := 64
+					if sizeID == 0 {
+						coefs = 16
+					}
+					if sizeID > 1 {
+						w.se(8)
+					}
+					for j := 0; j < coefs; j++ {
+						w.se(0)
+					}
+				} else { /*copied from a reference list*/
+					w.u(1, 0).ue(1)
+				}
+			}
+		}
+	}
+	w.u(2, 0).u(1, 0) /*amp, sao, pcm*/
+	if p.refSets {
+		w.ue(2)
+		w.ue(1).ue(0).ue(0).u(1, 1)
+		w.u(1, 1).u(1, 0).ue(0) /*inter prediction from set 0, two deltas*/
+		w.u(1, 1).u(1, 0).u(1, 1)
+	} else {
+		w.ue(0)
+	}
+	if w.flag(p.longTerm); p.longTerm {
+		w.ue(2).u(9, 0).u(9, 0)
+	}
+	w.u(2, 3)
+	if w.flag(p.vui); p.vui {
+		w.vuiHead(p.head)
+		w.u(3, 0)
+		if w.flag(p.display); p.display {
+			w.ue(0).ue(0).ue(8).ue(8)
+		}
+		if w.flag(p.timing); p.timing {
+			w.u(32, p.units).u(32, p.timeScale).u(1, 0)
+		}
+	}
+	return w.nalu()
+}
+
+func TestH265SPSFrameRate(t *testing.T) {
+	full := vuiHead{aspect: true, overscan: true, signal: true, chroma: true}
+	tests := []struct {
+		name string
+		sps  h265SPS
+		want float64
+	}{
+		{"plain 30", h265SPS{vui: true, timing: true, units: 1, timeScale: 30}, 30},
+		{"ntsc 59.94", h265SPS{vui: true, timing: true, units: 1001, timeScale: 60000}, 60000.0 / 1001},
+		{"sub layers", h265SPS{subLayers: 2, vui: true, timing: true, units: 1, timeScale: 50}, 50},
+		{"scaling lists", h265SPS{scaling: true, vui: true, timing: true, units: 1, timeScale: 25}, 25},
+		{"predicted ref sets and long term", h265SPS{refSets: true, longTerm: true, vui: true, timing: true, units: 1, timeScale: 60}, 60},
+		{"whole vui", h265SPS{conformant: true, vui: true, head: full, display: true, timing: true, units: 1, timeScale: 24}, 24},
+		{"no vui", h265SPS{}, 0},
+		{"vui without timing", h265SPS{vui: true, head: full}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := paramSetFrameRate(CodecH265, tt.sps.nalu())
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("frame rate %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func h265VPS(subLayers uint64, orderingAll bool, layerSets uint64, timing bool, units, timeScale uint64) []byte {
+	w := &bitWriter{}
+	w.u(16, 0x4001).u(4, 0).u(2, 3).u(6, 0).u(3, subLayers).u(1, 1).u(16, 0xffff)
+	w.profileTierLevel(subLayers)
+	first := subLayers
+	if w.flag(orderingAll); orderingAll {
+		first = 0
+	}
+	for i := first; i <= subLayers; i++ {
+		w.ue(4).ue(0).ue(0)
+	}
+	w.u(6, 2).ue(layerSets)
+	for i := uint64(0); i < layerSets; i++ {
+		w.u(3, 1)
+	}
+	if w.flag(timing); timing {
+		w.u(32, units).u(32, timeScale).u(1, 0)
+	}
+	return w.nalu()
+}
+
+func TestH265VPSFrameRate(t *testing.T) {
+	tests := []struct {
+		name string
+		vps  []byte
+		want float64
+	}{
+		{"plain 25", h265VPS(0, false, 0, true, 1, 25), 25},
+		{"sub layers and ordering info", h265VPS(2, true, 0, true, 1001, 30000), 30000.0 / 1001},
+		{"layer sets", h265VPS(1, false, 2, true, 1, 50), 50},
+		{"no timing", h265VPS(0, false, 0, false, 0, 0), 0},
+		{"truncated", []byte{0x40, 0x01, 0x0c, 0x01, 0xff, 0xff}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := paramSetFrameRate(CodecH265, tt.vps)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("frame rate %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParamSetFrameRateOther(t *testing.T) {
+	sps := h264SPS{profile: 66, vui: true, timing: true, units: 1, scale: 50}.nalu()
+	tests := []struct {
+		name  string
+		codec string
+		nalu  []byte
+	}{
+		{"h264 idr", CodecH264, []byte{0x65, 0x88, 0x84, 0x00}},
+		{"h264 pps", CodecH264, []byte{0x68, 0xce, 0x3c, 0x80}},
+		{"h264 sps cut short", CodecH264, sps[:len(sps)/2]},
+		{"too short", CodecH264, []byte{0x67, 0x42}},
+		{"h265 idr", CodecH265, []byte{0x26, 0x01, 0xaf, 0x00}},
+		{"h264 sps read as h265", CodecH265, sps},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := paramSetFrameRate(tt.codec, tt.nalu); got != 0 {
+				t.Errorf("frame rate %v, want 0", got)
+			}
+		})
+	}
+}
+
+func TestNaluToRBSP(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		want []byte
+	}{
+		{"nothing to drop", []byte{0x67, 0x42, 0x00, 0x1f}, []byte{0x67, 0x42, 0x00, 0x1f}},
+		{"one escape", []byte{0x00, 0x00, 0x03, 0x01}, []byte{0x00, 0x00, 0x01}},
+		{"escaped zeros", []byte{0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x00}, []byte{0x00, 0x00, 0x00, 0x00, 0x00}},
+		{"03 after a single zero stays", []byte{0x01, 0x00, 0x03, 0x02}, []byte{0x01, 0x00, 0x03, 0x02}},
+		{"trailing escape", []byte{0x10, 0x00, 0x00, 0x03}, []byte{0x10, 0x00, 0x00}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := naluToRBSP(tt.in); !bytes.Equal(got, tt.want) {
+				t.Errorf("rbsp % x, want % x", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+// stream-hub
+package rtsp
+
+import (
+	"encoding/binary"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+const hubQueueSize = 1024
+
+/*packets of one source, received or packetized once and fanned out to every subscribed transport*/
+type StreamHub struct {
+	subscribers map[*RtpTransport]*hubSubscriber
+	caches      map[int]*gopCache
+	lock        sync.RWMutex
+}
+
+/*video packets from the last keyframe on, replayed to viewers joining mid-stream*/
+type gopCache struct {
+	codec   string
+	limit   int
+	size    int
+	keyTs   uint32
+	full    bool
+	packets [][]byte
+	lock    sync.Mutex
+}
+
+/*per viewer queue and ssrc/seq/timestamp rewriting, a slow viewer drops packets instead of stalling the others*/
+type hubSubscriber struct {
+	hub       *StreamHub
+	transport *RtpTransport
+	ssrc      uint32
+	seqBase   uint16
+	tsBase    uint32
+	seqOffset uint16
+	tsOffset  uint32
+	started   bool
+	resumed   bool   /*after a PAUSE: same ssrc, seq goes on from lastSeq, timestamps keep their offset*/
+	lastSeq   uint16 /*as sent*/
+	lastTs    uint32 /*as received*/
+	dropped   uint32
+	queue     chan hubPacket
+	done      chan struct{}
+}
+
+type hubPacket struct {
+	rtcp bool
+	data []byte
+}
+
+func NewStreamHub() *StreamHub {
+	return &StreamHub{
+		subscribers: make(map[*RtpTransport]*hubSubscriber),
+		caches:      make(map[int]*gopCache),
+	}
+}
+
+/*cache up to limit bytes of the track's current gop, a gop bigger than that is not cached*/
+func (h *StreamHub) EnableGopCache(track int, codec string, limit int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.caches[track] = &gopCache{codec: codec, limit: limit}
+}
+
+func (h *StreamHub) Subscribe(t *RtpTransport) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if _, ok := h.subscribers[t]; ok {
+		return
+	}
+	var burst [][]byte
+	if cache, ok := h.caches[t.Track]; ok {
+		burst = cache.snapshot()
+	}
+	sub := &hubSubscriber{
+		hub:       h,
+		transport: t,
+		ssrc:      rand.Uint32(),
+		seqBase:   uint16(rand.Uint32()),
+		tsBase:    rand.Uint32(),
+		queue:     make(chan hubPacket, hubQueueSize+len(burst)),
+		done:      make(chan struct{}),
+	}
+	/*the viewer's timeline starts at the cached keyframe and live packets follow on*/
+	for _, pkt := range burst {
+		sub.queue <- hubPacket{rtcp: false, data: pkt}
+	}
+	prev := t.hubState
+	if prev != nil && prev.hub != h {
+		prev = nil
+	}
+	t.hubState = sub
+	h.subscribers[t] = sub
+	go sub.run(prev)
+}
+
+func (h *StreamHub) Unsubscribe(t *RtpTransport) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if sub, ok := h.subscribers[t]; ok {
+		delete(h.subscribers, t)
+		close(sub.queue)
+	}
+}
+
+func (h *StreamHub) Count() int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return len(h.subscribers)
+}
+
+func (h *StreamHub) WritePacket(track int, pkt []byte) {
+	h.write(track, hubPacket{rtcp: false, data: pkt})
+}
+
+func (h *StreamHub) WriteRTCP(track int, pkt []byte) {
+	h.write(track, hubPacket{rtcp: true, data: pkt})
+}
+
+func (h *StreamHub) write(track int, p hubPacket) {
+	p.data = append([]byte(nil), p.data...) /*callers may reuse their buffer*/
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	if cache, ok := h.caches[track]; ok && !p.rtcp {
+		cache.add(p.data)
+	}
+	for t, sub := range h.subscribers {
+		if t.Track != track {
+			continue
+		}
+		select {
+		case sub.queue <- p:
+		default:
+			if dropped := atomic.AddUint32(&sub.dropped, 1); dropped%100 == 1 {
+				log.Printf("viewer too slow, %d packets dropped\n", dropped)
+			}
+		}
+	}
+}
+
+/*drops every subscriber and returns their transports*/
+func (h *StreamHub) Close() []*RtpTransport {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	var ts []*RtpTransport
+	for t, sub := range h.subscribers {
+		close(sub.queue)
+		ts = append(ts, t)
+	}
+	h.subscribers = make(map[*RtpTransport]*hubSubscriber)
+	return ts
+}
+
+func (g *gopCache) add(pkt []byte) {
+	payload := rtpPayload(pkt)
+	if payload == nil {
+		return
+	}
+	ts := binary.BigEndian.Uint32(pkt[4:])
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if isKeyFramePayload(g.codec, payload) && (len(g.packets) == 0 || ts != g.keyTs) {
+		g.packets, g.size, g.keyTs, g.full = nil, 0, ts, false
+	}
+	if g.full || (len(g.packets) == 0 && !isKeyFramePayload(g.codec, payload)) {
+		return
+	}
+	if g.size+len(pkt) > g.limit {
+		g.packets, g.size, g.full = nil, 0, true
+		return
+	}
+	g.packets = append(g.packets, pkt)
+	g.size += len(pkt)
+}
+
+func (g *gopCache) snapshot() [][]byte {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return append([][]byte(nil), g.packets...)
+}
+
+/*skips csrcs and the header extension*/
+func rtpPayload(pkt []byte) []byte {
+	if len(pkt) < 12 {
+		return nil
+	}
+	offset := 12 + int(pkt[0]&0x0f)*4
+	if pkt[0]&0x10 != 0 && len(pkt) >= offset+4 {
+		offset += 4 + int(binary.BigEndian.Uint16(pkt[offset+2:]))*4
+	}
+	if offset >= len(pkt) {
+		return nil
+	}
+	return pkt[offset:]
+}
+
+/*prev is the same viewer's subscription before a PAUSE, its state is taken over once it has sent its last packet*/
+func (sub *hubSubscriber) run(prev *hubSubscriber) {
+	defer close(sub.done)
+	if prev != nil {
+		<-prev.done
+		sub.ssrc = prev.ssrc
+		if prev.started {
+			sub.resumed = true
+			sub.seqBase = prev.lastSeq + 1
+			sub.tsOffset, sub.lastTs = prev.tsOffset, prev.lastTs
+		} else {
+			sub.seqBase, sub.tsBase = prev.seqBase, prev.tsBase
+		}
+	}
+	failed := false
+	for p := range sub.queue {
+		if failed {
+			continue
+		}
+		var err error
+		if p.rtcp {
+			if pkt := sub.rewriteRTCP(p.data); pkt != nil {
+				err = sub.transport.WriteRTCP(pkt)
+			}
+		} else if pkt := sub.rewriteRTP(p.data); pkt != nil {
+			err = sub.transport.WriteRTP(string(pkt))
+		}
+		if err != nil {
+			log.Println(err)
+			failed = true
+		}
+	}
+}
+
+/*seq and timestamp keep the source's gaps and spacing, shifted to this viewer's own random bases*/
+func (sub *hubSubscriber) rewriteRTP(data []byte) []byte {
+	if len(data) < 12 {
+		return nil
+	}
+	seq := binary.BigEndian.Uint16(data[2:])
+	ts := binary.BigEndian.Uint32(data[4:])
+	if !sub.started {
+		if sub.resumed && int32(ts-sub.lastTs) <= 0 { /*cached packets the viewer got before the PAUSE*/
+			return nil
+		}
+		sub.started = true
+		sub.seqOffset = sub.seqBase - seq
+		if !sub.resumed {
+			sub.tsOffset = sub.tsBase - ts
+		}
+	}
+	sub.lastSeq, sub.lastTs = seq+sub.seqOffset, ts
+	pkt := make([]byte, len(data))
+	copy(pkt, data)
+	binary.BigEndian.PutUint16(pkt[2:], sub.lastSeq)
+	binary.BigEndian.PutUint32(pkt[4:], ts+sub.tsOffset)
+	binary.BigEndian.PutUint32(pkt[8:], sub.ssrc)
+	return pkt
+}
+
+/*sender reports and sdes chunks are moved to this viewer's ssrc and timeline*/
+func (sub *hubSubscriber) rewriteRTCP(data []byte) []byte {
+	if !sub.started {
+		return nil
+	}
+	pkt := make([]byte, len(data))
+	copy(pkt, data)
+	for offset := 0; offset+8 <= len(pkt); {
+		size := (int(binary.BigEndian.Uint16(pkt[offset+2:])) + 1) * 4
+		if offset+size > len(pkt) {
+			break
+		}
+		switch pkt[offset+1] {
+		case 200: /*SR*/
+			binary.BigEndian.PutUint32(pkt[offset+4:], sub.ssrc)
+			if size >= 20 {
+				ts := binary.BigEndian.Uint32(pkt[offset+16:])
+				binary.BigEndian.PutUint32(pkt[offset+16:], ts+sub.tsOffset)
+			}
+		case 202: /*SDES*/
+			binary.BigEndian.PutUint32(pkt[offset+4:], sub.ssrc)
+		}
+		offset += size
+	}
+	return pkt
+}
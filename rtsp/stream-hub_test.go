@@ -0,0 +1,165 @@
+// stream-hub_test
+package rtsp
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+type rtpHead struct {
+	seq uint16
+	ts  uint32
+}
+
+func testRTP(h rtpHead) []byte {
+	pkt := make([]byte, 20)
+	pkt[0], pkt[1] = 0x80, 96
+	binary.BigEndian.PutUint16(pkt[2:], h.seq)
+	binary.BigEndian.PutUint32(pkt[4:], h.ts)
+	binary.BigEndian.PutUint32(pkt[8:], 0xdeadbeef)
+	pkt[12] = 0x41
+	return pkt
+}
+
+func TestHubRewriteRTP(t *testing.T) {
+	tests := []struct {
+		name    string
+		sub     hubSubscriber
+		in      []rtpHead
+		dropped int /*leading packets the viewer does not get*/
+		want    []rtpHead
+	}{
+		{
+			name: "contiguous",
+			sub:  hubSubscriber{seqBase: 5000, tsBase: 90000},
+			in:   []rtpHead{{100, 1000}, {101, 4000}, {102, 7000}},
+			want: []rtpHead{{5000, 90000}, {5001, 93000}, {5002, 96000}},
+		},
+		{
+			name: "gaps and reordering kept",
+			sub:  hubSubscriber{seqBase: 5000, tsBase: 90000},
+			in:   []rtpHead{{100, 1000}, {103, 10000}, {102, 7000}},
+			want: []rtpHead{{5000, 90000}, {5003, 99000}, {5002, 96000}},
+		},
+		{
+			name: "source wraps",
+			sub:  hubSubscriber{seqBase: 10, tsBase: 1000},
+			in:   []rtpHead{{65534, 0xffffff00}, {65535, 0xffffffff}, {0, 0x100}},
+			want: []rtpHead{{10, 1000}, {11, 1000 + 0xff}, {12, 1000 + 0x200}},
+		},
+		{
+			name: "viewer wraps",
+			sub:  hubSubscriber{seqBase: 65535, tsBase: 0xffffffff},
+			in:   []rtpHead{{7, 3000}, {8, 6000}},
+			want: []rtpHead{{65535, 0xffffffff}, {0, 2999}},
+		},
+		{
+			name: "same timestamp for a fragmented frame",
+			sub:  hubSubscriber{seqBase: 1, tsBase: 2},
+			in:   []rtpHead{{40, 9000}, {41, 9000}, {42, 12600}},
+			want: []rtpHead{{1, 2}, {2, 2}, {3, 3602}},
+		},
+		{
+			name:    "resumed after pause",
+			sub:     hubSubscriber{resumed: true, seqBase: 7001, tsOffset: 100, lastTs: 9000},
+			in:      []rtpHead{{500, 5400}, {501, 9000}, {600, 45000}, {601, 48600}},
+			dropped: 2,
+			want:    []rtpHead{{7001, 45100}, {7002, 48700}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := tt.sub
+			sub.ssrc = 0x01020304
+			var got []rtpHead
+			for i, h := range tt.in {
+				pkt := sub.rewriteRTP(testRTP(h))
+				if i < tt.dropped {
+					if pkt != nil {
+						t.Errorf("packet %d sent, it was sent before the pause", i)
+					}
+					continue
+				}
+				if pkt == nil {
+					t.Fatalf("packet %d dropped", i)
+				}
+				if ssrc := binary.BigEndian.Uint32(pkt[8:]); ssrc != 0x01020304 {
+					t.Errorf("packet %d ssrc %x", i, ssrc)
+				}
+				if pkt[12] != 0x41 || pkt[1] != 96 {
+					t.Errorf("packet %d payload or header changed", i)
+				}
+				got = append(got, rtpHead{binary.BigEndian.Uint16(pkt[2:]), binary.BigEndian.Uint32(pkt[4:])})
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("packet %d: got %v, want %v", i+tt.dropped, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHubRewriteRTPKeepsSource(t *testing.T) {
+	sub := hubSubscriber{ssrc: 1, seqBase: 2, tsBase: 3}
+	in := testRTP(rtpHead{100, 1000})
+	sub.rewriteRTP(in)
+	if binary.BigEndian.Uint16(in[2:]) != 100 || binary.BigEndian.Uint32(in[8:]) != 0xdeadbeef {
+		t.Errorf("the source packet, shared by every viewer, was changed")
+	}
+	if sub.rewriteRTP([]byte{0x80, 96, 0, 1}) != nil {
+		t.Errorf("a packet shorter than the rtp header went through")
+	}
+}
+
+func TestHubRewriteRTCP(t *testing.T) {
+	sr := func(ts uint32) []byte {
+		pkt := make([]byte, 28)
+		pkt[0], pkt[1], pkt[3] = 0x80, 200, 6
+		binary.BigEndian.PutUint32(pkt[4:], 0xdeadbeef)
+		binary.BigEndian.PutUint32(pkt[16:], ts)
+		return pkt
+	}
+	sdes := []byte{0x81, 202, 0, 2, 0xde, 0xad, 0xbe, 0xef, 1, 0, 0, 0}
+	rr := []byte{0x80, 201, 0, 1, 0xde, 0xad, 0xbe, 0xef}
+	tests := []struct {
+		name  string
+		in    []byte
+		ssrcs []int /*offsets of the ssrc fields that move to the viewer's*/
+		ts    map[int]uint32
+	}{
+		{"sender report", sr(1000), []int{4}, map[int]uint32{16: 1000 + 500}},
+		{"sender report and sdes", append(sr(0xffffffff), sdes...), []int{4, 32}, map[int]uint32{16: 499}},
+		{"receiver report untouched", rr, nil, nil},
+		{"truncated compound", append(sr(2000), 0x81, 202, 0, 9), []int{4}, map[int]uint32{16: 2500}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := hubSubscriber{ssrc: 0x01020304, started: true, tsOffset: 500}
+			pkt := sub.rewriteRTCP(tt.in)
+			if len(pkt) != len(tt.in) {
+				t.Fatalf("%d bytes, want %d", len(pkt), len(tt.in))
+			}
+			want := append([]byte(nil), tt.in...)
+			for _, off := range tt.ssrcs {
+				binary.BigEndian.PutUint32(want[off:], 0x01020304)
+			}
+			for off, ts := range tt.ts {
+				binary.BigEndian.PutUint32(want[off:], ts)
+			}
+			for i := range want {
+				if pkt[i] != want[i] {
+					t.Fatalf("got % x\nwant % x", pkt, want)
+				}
+			}
+		})
+	}
+
+	sub := hubSubscriber{ssrc: 1}
+	if sub.rewriteRTCP(sr(1000)) != nil {
+		t.Errorf("a sender report went out before the first rtp packet")
+	}
+}
@@ -0,0 +1,94 @@
+// tls-listener
+package rtsp
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+/*keeps the certificate of the rtsps listener, reloads it when either file changes on disk*/
+type certLoader struct {
+	CertFile string
+	KeyFile  string
+	cert     *tls.Certificate
+	modTime  time.Time
+	checked  time.Time
+	lock     sync.Mutex
+}
+
+func newCertLoader(certFile string, keyFile string) (*certLoader, error) {
+	l := &certLoader{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+		cert:     nil,
+	}
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+/*newest modification time of the pair*/
+func (l *certLoader) stat() (time.Time, error) {
+	var newest time.Time
+	for _, name := range []string{l.CertFile, l.KeyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return newest, err
+		}
+		if fi.ModTime().After(newest) {
+			newest = fi.ModTime()
+		}
+	}
+	return newest, nil
+}
+
+func (l *certLoader) load() error {
+	modTime, err := l.stat()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(l.CertFile, l.KeyFile)
+	if err != nil {
+		return err
+	}
+	l.cert, l.modTime = &cert, modTime
+	return nil
+}
+
+/*checks the files at most once a second; a broken renewal keeps serving the old certificate*/
+func (l *certLoader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if time.Since(l.checked) > time.Second {
+		l.checked = time.Now()
+		if modTime, err := l.stat(); err == nil && !modTime.Equal(l.modTime) {
+			if err := l.load(); err != nil {
+				log.Println("reload certificate:", err)
+			} else {
+				log.Println("certificate reloaded from", l.CertFile)
+			}
+		}
+	}
+	return l.cert, nil
+}
+
+func listenTLS(port uint16, certs *certLoader) (net.Listener, error) {
+	l, err := listenTCP(port)
+	if err != nil {
+		return nil, err
+	}
+	return tls.NewListener(l, &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}), nil
+}
+
+func (c *ClientConnection) secure() bool {
+	_, ok := c.Conn.(*tls.Conn)
+	return ok
+}
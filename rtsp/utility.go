@@ -12,6 +12,7 @@ type RequestInfo struct {
 	URL     string
 	Version string
 	Headers map[string]string
+	Body    []byte
 }
 
 func ParseReqBuf(reqString string) *RequestInfo {
@@ -65,6 +66,38 @@ func parseRespBuf(respStr string) *ResponseInfo {
 	}
 }
 
+/*"npt=10-20.5", "npt=0:01:05-", "npt=now-"; start is -1 for now, end is 0 when open*/
+func parseNptRange(value string) (start float64, end float64, ok bool) {
+	m := regexp.MustCompile("npt\\s*=\\s*([^-\\s]*)-([^;\\s]*)").FindStringSubmatch(value)
+	if m == nil {
+		return 0, 0, false
+	}
+	if m[1] == "now" {
+		start = -1
+	} else if start, ok = parseNpt(m[1]); !ok {
+		return 0, 0, false
+	}
+	if m[2] != "" {
+		if end, ok = parseNpt(m[2]); !ok {
+			return 0, 0, false
+		}
+	}
+	return start, end, true
+}
+
+/*seconds, or h:mm:ss.frac*/
+func parseNpt(s string) (float64, bool) {
+	seconds := 0.0
+	for _, part := range strings.Split(s, ":") {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v < 0 {
+			return 0, false
+		}
+		seconds = seconds*60 + v
+	}
+	return seconds, true
+}
+
 func parseWWWAuth(autStr string) (realm, nonce string) {
 	realm = ""
 	nonce = ""
@@ -0,0 +1,60 @@
+// utility_test
+package rtsp
+
+import "testing"
+
+func TestParseNptRange(t *testing.T) {
+	tests := []struct {
+		value      string
+		start, end float64
+		ok         bool
+	}{
+		{"npt=0-", 0, 0, true},
+		{"npt=0.000-", 0, 0, true},
+		{"npt=10.5-20", 10.5, 20, true},
+		{"npt=now-", -1, 0, true},
+		{"npt=1:02:03.5-", 3723.5, 0, true},
+		{"npt=0:30-1:00", 30, 60, true},
+		{"npt = 5-7", 5, 7, true},
+		{"npt=5-7;time=19970123T143720Z", 5, 7, true},
+		{"npt=abc-", 0, 0, false},
+		{"npt=10-x", 0, 0, false},
+		{"npt=1:-5-", 0, 0, false},
+		{"clock=19961108T143720.25Z-", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			start, end, ok := parseNptRange(tt.value)
+			if ok != tt.ok || start != tt.start || end != tt.end {
+				t.Errorf("got %v %v %v, want %v %v %v", start, end, ok, tt.start, tt.end, tt.ok)
+			}
+		})
+	}
+}
+
+func TestParseNpt(t *testing.T) {
+	tests := []struct {
+		value string
+		want  float64
+		ok    bool
+	}{
+		{"12", 12, true},
+		{"12.25", 12.25, true},
+		{"1:30", 90, true},
+		{"0:01:30.5", 90.5, true},
+		{"1:00:00", 3600, true},
+		{"-3", 0, false},
+		{"", 0, false},
+		{"1:x", 0, false},
+		{"1::2", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, ok := parseNpt(tt.value)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("got %v %v, want %v %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}